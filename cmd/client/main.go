@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/ozeidan/gosearch/pkg/client"
 )
@@ -10,21 +16,38 @@ import (
 func main() {
 	fuzzyFlag := flag.Bool("f", false, "use fuzzy searching")
 	prefixFlag := flag.Bool("p", false, "do a prefix search (faster)")
+	regexFlag := flag.Bool("e", false, "match the names against a regular expression")
 	noSortFlag := flag.Bool("nosort", false,
 		"don't sort the result set for performance gains when fuzzy searching")
 	reverseSortFlag := flag.Bool("r", false, "reverse the sort order")
 	caseInsensitiveFlag := flag.Bool("c", false, "case-insensitive searching")
+	caseSensitiveFlag := flag.Bool("s", false,
+		"case-sensitive searching, by default queries without upper-case letters are case-insensitive,\n"+
+			"for regular expressions only the literal characters are checked")
+	accentInsensitiveFlag := flag.Bool("a", false,
+		"accent-insensitive searching, e.g. resume matches résumé")
 	maxResultsFlag := flag.Int("n", 250,
 		"maximum amount of results to display, set to 0 for unlimited results")
+	statusFlag := flag.Bool("status", false, "print the status of the server")
 
 	flag.Parse()
 
+	if *statusFlag {
+		printStatus()
+		return
+	}
+
 	if flag.NArg() < 1 {
 		flag.Usage()
 		return
 	}
 
-	if *fuzzyFlag && *prefixFlag {
+	if btoi(*fuzzyFlag)+btoi(*prefixFlag)+btoi(*regexFlag) > 1 {
+		flag.Usage()
+		return
+	}
+
+	if *caseInsensitiveFlag && *caseSensitiveFlag {
 		flag.Usage()
 		return
 	}
@@ -41,6 +64,9 @@ func main() {
 	if *prefixFlag {
 		options = append(options, client.PrefixSearch)
 	}
+	if *regexFlag {
+		options = append(options, client.Regex)
+	}
 	if *noSortFlag {
 		options = append(options, client.NoSort)
 	}
@@ -50,16 +76,114 @@ func main() {
 	if *caseInsensitiveFlag {
 		options = append(options, client.CaseInsensitive)
 	}
+	if !*caseSensitiveFlag {
+		options = append(options, client.SmartCase)
+	}
+	if *accentInsensitiveFlag {
+		options = append(options, client.AccentInsensitive)
+	}
 
-	responseChan, err := client.SearchRequest(query, options...)
+	responseChan, err := client.Search(query, options...)
 
 	if err == client.ErrConnectionFailed {
 		fmt.Println(err)
 		fmt.Println("is the server running?")
 		return
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gosearch:", err)
+		os.Exit(1)
+	}
 
+	// the characters matched by fuzzy searches are highlighted
+	// on terminals, NO_COLOR disables it like in other programs
+	color := isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
 	for response := range responseChan {
-		fmt.Print(response)
+		if response.Notice != "" {
+			fmt.Fprintln(os.Stderr, "gosearch:", response.Notice)
+			continue
+		}
+		if color && len(response.Matches) > 0 {
+			fmt.Println(highlight(response.Path, response.Matches))
+			continue
+		}
+		fmt.Println(response.Path)
+	}
+}
+
+func printStatus() {
+	status, err := client.Status()
+	if err == client.ErrConnectionFailed {
+		fmt.Println(err)
+		fmt.Println("is the server running?")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gosearch:", err)
+		os.Exit(1)
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, status, "", "  "); err != nil {
+		fmt.Println(string(status))
+		return
+	}
+	fmt.Println(indented.String())
+}
+
+const (
+	highlightStart = "\x1b[1;32m"
+	highlightEnd   = "\x1b[0m"
+)
+
+// highlight returns path with the characters at the byte offsets
+// in matches, which are sorted, wrapped in terminal escape codes
+func highlight(path string, matches []int) string {
+	var builder strings.Builder
+	written, open := 0, false
+	for _, offset := range matches {
+		if offset < written || offset >= len(path) {
+			continue
+		}
+		// the combining marks are highlighted with their character
+		_, end := utf8.DecodeRuneInString(path[offset:])
+		end += offset
+		for end < len(path) {
+			r, size := utf8.DecodeRuneInString(path[end:])
+			if !unicode.Is(unicode.Mn, r) {
+				break
+			}
+			end += size
+		}
+
+		// adjacent characters are highlighted together
+		if open && offset != written {
+			builder.WriteString(highlightEnd)
+			open = false
+		}
+		builder.WriteString(path[written:offset])
+		if !open {
+			builder.WriteString(highlightStart)
+			open = true
+		}
+		builder.WriteString(path[offset:end])
+		written = end
+	}
+	if open {
+		builder.WriteString(highlightEnd)
+	}
+	builder.WriteString(path[written:])
+	return builder.String()
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -1,37 +1,256 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/ozeidan/gosearch/internal/coalescer"
 	"github.com/ozeidan/gosearch/internal/config"
 	"github.com/ozeidan/gosearch/internal/database"
-	"github.com/ozeidan/gosearch/internal/fanotify"
+	"github.com/ozeidan/gosearch/internal/metrics"
 	"github.com/ozeidan/gosearch/internal/request"
+	"github.com/ozeidan/gosearch/internal/systemd"
+	"github.com/pkg/errors"
 )
 
 func main() {
-	err := config.ParseConfig()
+	userFlag := flag.Bool("user", false,
+		"run as an unprivileged user, indexing the home directory")
+	ignoreConfigErrorsFlag := flag.Bool("ignore-config-errors", false,
+		"start even if the config file contains errors")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Usage: %s [flags] [check-config [config file]]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *userFlag || os.Geteuid() != 0 {
+		config.EnableUserMode()
+	}
+
+	if flag.Arg(0) == "check-config" {
+		path := config.ConfigPath()
+		if flag.NArg() > 1 {
+			path = flag.Arg(1)
+		}
+		os.Exit(checkConfig(path, true))
+	} else if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := os.Stat(config.ConfigPath()); err == nil &&
+		checkConfig(config.ConfigPath(), false) != 0 && !*ignoreConfigErrorsFlag {
+		slog.Error("refusing to start with a broken config file, " +
+			"fix it or start with -ignore-config-errors")
+		os.Exit(1)
+	}
+
+	// the first SIGINT or SIGTERM shuts the server down gracefully,
+	// a second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := run(ctx, *ignoreConfigErrorsFlag); err != nil {
+		slog.Error("server failed", "err", err)
+		os.Exit(1)
+	}
+}
+
+// progressInterval is the interval in which the indexing
+// progress is reported to the service manager
+const progressInterval = time.Second
+
+// shutdownTimeout is the time that active requests
+// are given to finish when the server is shut down
+const shutdownTimeout = 5 * time.Second
+
+// run starts the server and serves requests until ctx is done,
+// it fails if the config file can't be parsed unless ignoreConfigErrors
+// is set, the default configuration is used then
+func run(ctx context.Context, ignoreConfigErrors bool) error {
+	// the sockets passed by systemd are taken over
+	// before any other file is opened
+	listeners, err := systemd.Listeners()
 	if err != nil {
-		log.Println("failed to initialize configuration", err)
+		return err
+	}
+
+	// a stub is created if there is no config file
+	_, statErr := os.Stat(config.ConfigPath())
+	err = config.ParseConfig()
+	if err != nil && statErr == nil && !ignoreConfigErrors {
+		return errors.Wrap(err, "failed to parse the config file, "+
+			"fix it or start with -ignore-config-errors")
+	} else if err != nil {
+		slog.Error("failed to initialize configuration", "err", err)
 	}
 
 	err = config.SetupLogging()
 	if err != nil {
-		log.Println(err)
-		return
+		return errors.Wrap(err, "failed to set up logging")
+	}
+
+	if config.UserMode() {
+		slog.Info("running in user mode")
+	}
+
+	var metricsServer *http.Server
+	if address := config.MetricsAddress(); address != "" {
+		slog.Info("serving metrics", "address", address)
+		metricsServer = metrics.NewServer(address)
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != http.ErrServerClosed {
+				slog.Error("failed to serve metrics", "err", err)
+			}
+		}()
 	}
 
-	fileChangeChan := make(chan fanotify.FileChange, 100)
+	slog.Info("indexing", "roots", config.Roots(), "version", config.Version)
+	w, err := newRootWatchers()
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize watcher")
+	}
+	slog.Info("watching for file changes", "watcher", w.Name())
+
 	requestChan := make(chan request.Request)
-	go fanotify.Listen(fileChangeChan)
-	go database.Start(fileChangeChan, requestChan)
-	go request.ListenAndServe(requestChan)
+	go database.Start(coalescer.New(w, config.CoalesceWindow(), database.Done()), requestChan)
+	server, err := listen(listeners, requestChan)
+	if err != nil {
+		shutdownContext, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		database.Stop(shutdownContext)
+		return err
+	}
+	go server.Serve()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	for range c {
-		break
+	// the service manager is informed about the indexing progress
+	// and is told that the server is ready once the index is built
+	indexed := database.Ready()
+	progress := time.NewTicker(progressInterval)
+	defer progress.Stop()
+	progressTicks := progress.C
+
+	var watchdogTicks <-chan time.Time
+	interval, ok := systemd.WatchdogInterval()
+	if ok {
+		watchdog := time.NewTicker(interval / 2)
+		defer watchdog.Stop()
+		watchdogTicks = watchdog.C
+	}
+
+	for {
+		select {
+		case <-hangup:
+			notify("RELOADING=1")
+			reload()
+			if indexed == nil {
+				notify("READY=1")
+			}
+		case <-indexed:
+			indexed = nil
+			progressTicks = nil
+			files, directories := database.IndexSize()
+			notify(fmt.Sprintf("READY=1\nSTATUS=indexed %d files and %d directories",
+				files, directories))
+		case <-progressTicks:
+			files, directories := database.IndexSize()
+			notify(fmt.Sprintf("STATUS=indexing, %d files and %d directories so far",
+				files, directories))
+		case <-watchdogTicks:
+			// the watchdog is only kept alive while the database
+			// answers, so a server that hangs gets restarted
+			pingContext, cancel := context.WithTimeout(ctx, interval/4)
+			err := database.Ping(pingContext)
+			cancel()
+			if err != nil {
+				slog.Warn("the database didn't answer the watchdog ping", "err", err)
+			} else {
+				notify("WATCHDOG=1")
+			}
+		case <-ctx.Done():
+			notify("STOPPING=1")
+			shutdown(server, metricsServer)
+			return nil
+		}
+	}
+}
+
+// shutdown stops accepting requests, waits for the active request to be
+// answered, stops the database and closes the watchers, requests that
+// aren't done within shutdownTimeout are cancelled
+func shutdown(server *request.Server, metricsServer *http.Server) {
+	slog.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("failed to finish the active requests", "err", err)
+	}
+	if err := database.Stop(ctx); err != nil {
+		slog.Warn("failed to stop the database", "err", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Warn("failed to stop serving metrics", "err", err)
+		}
+	}
+	slog.Info("shut down")
+}
+
+// checkConfig prints the problems of the config file at path
+// and returns the exit code for the check-config command,
+// verbose also reports a config file without problems
+func checkConfig(path string, verbose bool) int {
+	problems, err := config.ValidateFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s: %s\n", path, p.Line, p.Severity, p.Message)
+	}
+
+	if config.HasErrors(problems) {
+		return 1
+	}
+	if verbose && len(problems) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no problems found\n", path)
+	}
+	return 0
+}
+
+// reload applies changes of the config file to the index
+// and recreates the watchers if the roots changed
+func reload() {
+	slog.Info("reloading configuration")
+	result := database.Reload()
+	if result.Error != "" || !result.RootsChanged {
+		return
+	}
+
+	w, err := newRootWatchers()
+	if err != nil {
+		slog.Error("failed to recreate watchers, keeping the old ones", "err", err)
+		return
 	}
+	slog.Info("watching for file changes", "watcher", w.Name())
+	database.SetWatcher(coalescer.New(w, config.CoalesceWindow(), database.Done()))
 }
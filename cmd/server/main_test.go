@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/request"
+	"github.com/ozeidan/gosearch/pkg/client"
+)
+
+// setupUserMode runs the server in user mode with the
+// directories of the user below dir, it returns the config file
+// and the home directory
+func setupUserMode(t *testing.T, dir string) (string, string) {
+	home := filepath.Join(dir, "home")
+	for _, env := range []string{"HOME", "XDG_CONFIG_HOME", "XDG_RUNTIME_DIR", "XDG_STATE_HOME"} {
+		path := filepath.Join(dir, env)
+		if env == "HOME" {
+			path = home
+		}
+		if err := os.MkdirAll(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(env, path)
+	}
+	config.EnableUserMode()
+
+	configPath := config.ConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return configPath, home
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath, home := setupUserMode(t, dir)
+	if err := ioutil.WriteFile(configPath, []byte(`{"print_logs": false}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, "needle"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	notifySocket := filepath.Join(dir, "notify.sock")
+	notifications, err := net.ListenUnixgram("unixgram",
+		&net.UnixAddr{Name: notifySocket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifications.Close()
+	t.Setenv("NOTIFY_SOCKET", notifySocket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- run(ctx, false)
+	}()
+
+	if got := searchUntilIndexed(t, "needle"); got != filepath.Join(home, "needle") {
+		t.Errorf("search returned %q, want %q", got, filepath.Join(home, "needle"))
+	}
+
+	waitForNotification(t, notifications, "READY=1")
+
+	cancel()
+	waitForNotification(t, notifications, "STOPPING=1")
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("run() = %v", err)
+		}
+	case <-time.After(2 * shutdownTimeout):
+		t.Fatal("the server didn't shut down")
+	}
+
+	if _, err := os.Stat(request.UserSockAddr()); !os.IsNotExist(err) {
+		t.Errorf("the socket wasn't removed: %v", err)
+	}
+}
+
+func TestRun_BrokenConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath, _ := setupUserMode(t, dir)
+	if err := ioutil.WriteFile(configPath, []byte(`{"print_logs": "no"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(context.Background(), false); err == nil {
+		t.Errorf("run() started with a config file that can't be parsed")
+	}
+}
+
+// waitForNotification reads the notifications sent to systemd
+// until one of them starts with state
+func waitForNotification(t *testing.T, conn *net.UnixConn, state string) {
+	buff := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := conn.Read(buff)
+		if err != nil {
+			t.Fatalf("didn't receive %s: %v", state, err)
+		}
+		if strings.HasPrefix(string(buff[:n]), state) {
+			return
+		}
+	}
+}
+
+// searchUntilIndexed searches for query until the server answers
+// with a result and returns the first one
+func searchUntilIndexed(t *testing.T, query string) string {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		responses, err := client.Search(query)
+		if err == nil {
+			for response := range responses {
+				if response.Path != "" {
+					return response.Path
+				}
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the server didn't answer")
+	return ""
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/request"
+	"github.com/ozeidan/gosearch/internal/systemd"
+)
+
+// listen returns a server for the socket passed by systemd if the
+// server was socket activated, otherwise the socket is created
+func listen(listeners []net.Listener,
+	requestChan chan<- request.Request) (*request.Server, error) {
+	if len(listeners) > 0 {
+		if len(listeners) > 1 {
+			slog.Warn("received more than one socket, only using the first one",
+				"sockets", len(listeners))
+			for _, l := range listeners[1:] {
+				l.Close()
+			}
+		}
+		slog.Info("using the socket passed by systemd", "address", listeners[0].Addr().String())
+		return request.NewServer(listeners[0], requestChan), nil
+	}
+
+	sockAddr := request.SockAddr
+	if config.UserMode() {
+		sockAddr = request.UserSockAddr()
+	}
+	return request.Listen(sockAddr, requestChan)
+}
+
+// notify sends state to systemd if the server is run as a notify service
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		slog.Warn("failed to notify systemd", "state", state, "err", err)
+	}
+}
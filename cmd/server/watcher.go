@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/fanotify"
+	"github.com/ozeidan/gosearch/internal/inotify"
+	"github.com/ozeidan/gosearch/internal/rescan"
+	"github.com/ozeidan/gosearch/internal/watcher"
+)
+
+// newRootWatchers creates a watcher for every backend that is
+// configured for one of the roots
+func newRootWatchers() (watcher.Watcher, error) {
+	var backends []string
+	backendRoots := make(map[string][]string)
+	for _, root := range config.Roots() {
+		backend := config.RootWatcherBackend(root)
+		if _, ok := backendRoots[backend]; !ok {
+			backends = append(backends, backend)
+		}
+		backendRoots[backend] = append(backendRoots[backend], root)
+	}
+
+	watchers := make([]watcher.Watcher, 0, len(backends))
+	for _, backend := range backends {
+		w, err := newWatcher(backend, config.RemoveNested(backendRoots[backend]))
+		if err != nil {
+			for _, created := range watchers {
+				created.Close()
+			}
+			return nil, err
+		}
+		watchers = append(watchers, w)
+	}
+
+	return watcher.Multi(watchers...), nil
+}
+
+// newWatcher creates the configured watcher backend for roots,
+// in auto mode fanotify is preferred and inotify or periodic
+// rescanning are used as fallbacks
+// fanotify requires root privileges and isn't used in user mode
+func newWatcher(backend string, roots []string) (watcher.Watcher, error) {
+	if config.UserMode() && backend == "fanotify" {
+		return nil, errors.New("the fanotify backend can't be used in user mode")
+	}
+
+	switch backend {
+	case "fanotify":
+		return fanotify.New(roots)
+	case "inotify":
+		return inotify.New(roots, config.RescanInterval())
+	case "rescan":
+		return rescan.New(roots, config.RescanInterval()), nil
+	case "auto":
+		if !config.UserMode() {
+			fan, err := fanotify.New(roots)
+			if err == nil {
+				return fan, nil
+			}
+			slog.Warn("fanotify is not available, falling back to inotify", "err", err)
+		}
+
+		in, err := inotify.New(roots, config.RescanInterval())
+		if err == nil {
+			return in, nil
+		}
+		slog.Warn("inotify is not available, falling back to rescanning", "err", err)
+
+		return rescan.New(roots, config.RescanInterval()), nil
+	default:
+		return nil, fmt.Errorf("unknown watcher backend %q", backend)
+	}
+}
@@ -1,8 +1,11 @@
 module github.com/ozeidan/gosearch
 
+go 1.21
+
 require (
 	github.com/karrick/godirwalk v1.9.0
 	github.com/pkg/errors v0.8.1
 	golang.org/x/sys v0.0.0-20190502175342-a43fa875dd82
+	golang.org/x/text v0.16.0
 	gopkg.in/ozeidan/fuzzy-patricia.v3 v3.0.0
 )
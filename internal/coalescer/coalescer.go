@@ -0,0 +1,221 @@
+package coalescer
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/metrics"
+	"github.com/ozeidan/gosearch/internal/watcher"
+)
+
+// Stats holds counters about the events that went through the coalescer
+type Stats struct {
+	// Received is the amount of events received from the listener
+	Received uint64
+	// Deduplicated is the amount of events that were dropped because
+	// a refresh of the same directory was already pending
+	Deduplicated uint64
+	// Collapsed is the amount of directories that were merged into
+	// a pending refresh of one of their ancestors
+	Collapsed uint64
+	// Emitted is the amount of refreshes that were passed on
+	Emitted uint64
+}
+
+var stats Stats
+
+func init() {
+	counters := []struct {
+		name, help string
+		value      *uint64
+	}{
+		{"gosearch_coalescer_received_total",
+			"Number of changes received by the coalescer.", &stats.Received},
+		{"gosearch_coalescer_deduplicated_total",
+			"Number of changes dropped because the directory was already pending.", &stats.Deduplicated},
+		{"gosearch_coalescer_collapsed_total",
+			"Number of directories merged into a pending ancestor.", &stats.Collapsed},
+		{"gosearch_coalescer_emitted_total",
+			"Number of refreshes passed on by the coalescer.", &stats.Emitted},
+	}
+	for _, c := range counters {
+		value := c.value
+		metrics.NewCounterFunc(c.name, c.help, func() float64 {
+			return float64(atomic.LoadUint64(value))
+		})
+	}
+}
+
+// GetStats returns a snapshot of the coalescer counters
+func GetStats() Stats {
+	return Stats{
+		Received:     atomic.LoadUint64(&stats.Received),
+		Deduplicated: atomic.LoadUint64(&stats.Deduplicated),
+		Collapsed:    atomic.LoadUint64(&stats.Collapsed),
+		Emitted:      atomic.LoadUint64(&stats.Emitted),
+	}
+}
+
+// Coalescer is a watcher.Watcher that merges the changes
+// of another watcher
+type Coalescer struct {
+	watcher watcher.Watcher
+	window  time.Duration
+	done    <-chan struct{}
+}
+
+// New returns a Coalescer merging the changes of w within window,
+// done is closed when the changes aren't read anymore
+func New(w watcher.Watcher, window time.Duration, done <-chan struct{}) *Coalescer {
+	return &Coalescer{w, window, done}
+}
+
+// Listen starts the underlying watcher and sends its
+// merged changes through changeReceiver
+func (c *Coalescer) Listen(changeReceiver chan<- watcher.FileChange) {
+	changes := make(chan watcher.FileChange, 100)
+	go func() {
+		c.watcher.Listen(changes)
+		close(changes)
+	}()
+	Start(c.window, changes, changeReceiver, c.done)
+}
+
+// Close stops the underlying watcher
+func (c *Coalescer) Close() error {
+	return c.watcher.Close()
+}
+
+// Name returns the name of the underlying watcher's backend
+func (c *Coalescer) Name() string {
+	return c.watcher.Name()
+}
+
+// Start merges bursts of file changes before passing them on.
+// Changes received from changeSender are collected for the duration
+// of window, starting with the first change of a burst. Changes of the
+// same directory are deduplicated and changes of directories below
+// another changed directory are attached to the ancestor's change as
+// Descendants, so that the database can skip them when the ancestor's
+// refresh already covered them.
+// The merged changes are sent through changeReceiver.
+// A window of zero passes all changes through unmodified.
+// When changeSender is closed, the collected changes are still sent,
+// Start only gives up sending them when done is closed.
+func Start(window time.Duration, changeSender <-chan watcher.FileChange,
+	changeReceiver chan<- watcher.FileChange, done <-chan struct{}) {
+	if window <= 0 {
+		for change := range changeSender {
+			atomic.AddUint64(&stats.Received, 1)
+			if !send(change, changeReceiver, done) {
+				return
+			}
+		}
+		return
+	}
+
+	pending := make(map[string]watcher.FileChange)
+	var ready []watcher.FileChange
+	var timer <-chan time.Time
+
+	for {
+		var sendChan chan<- watcher.FileChange
+		var next watcher.FileChange
+		if len(ready) > 0 {
+			sendChan = changeReceiver
+			next = ready[0]
+		}
+
+		select {
+		case change, ok := <-changeSender:
+			if !ok {
+				// the watcher is closed when it is replaced on a reload,
+				// the receiver keeps reading the changes of the new one
+				for _, change := range append(ready, collapse(pending)...) {
+					if !send(change, changeReceiver, done) {
+						return
+					}
+				}
+				return
+			}
+			atomic.AddUint64(&stats.Received, 1)
+			if _, ok := pending[change.FolderPath]; ok {
+				atomic.AddUint64(&stats.Deduplicated, 1)
+			}
+			pending[change.FolderPath] = change
+			if timer == nil {
+				timer = time.After(window)
+			}
+		case <-timer:
+			timer = nil
+			merged := collapse(pending)
+			if len(merged) < len(pending) {
+				slog.Debug("coalesced directories", "directories", len(pending),
+					"refreshes", len(merged))
+			}
+			ready = append(ready, merged...)
+			pending = make(map[string]watcher.FileChange)
+		case sendChan <- next:
+			atomic.AddUint64(&stats.Emitted, 1)
+			ready = ready[1:]
+		case <-done:
+			return
+		}
+	}
+}
+
+// send sends change through changeReceiver,
+// it returns false if done was closed instead
+func send(change watcher.FileChange, changeReceiver chan<- watcher.FileChange,
+	done <-chan struct{}) bool {
+	select {
+	case changeReceiver <- change:
+		atomic.AddUint64(&stats.Emitted, 1)
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// collapse merges the pending changes of directories into the changes
+// of their closest pending ancestor
+func collapse(pending map[string]watcher.FileChange) []watcher.FileChange {
+	paths := make([]string, 0, len(pending))
+	for path := range pending {
+		paths = append(paths, path)
+	}
+	// ancestors always sort before their descendants
+	sort.Strings(paths)
+
+	var merged []watcher.FileChange
+	rootIndex := make(map[string]int, len(paths))
+
+	for _, path := range paths {
+		if i, ok := pendingAncestor(path, rootIndex); ok {
+			merged[i].Descendants = append(merged[i].Descendants, path)
+			atomic.AddUint64(&stats.Collapsed, 1)
+			continue
+		}
+
+		change := pending[path]
+		change.Descendants = nil
+		rootIndex[path] = len(merged)
+		merged = append(merged, change)
+	}
+
+	return merged
+}
+
+func pendingAncestor(path string, rootIndex map[string]int) (int, bool) {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if i, ok := rootIndex[dir]; ok {
+			return i, true
+		}
+		if dir == "/" || dir == "." {
+			return 0, false
+		}
+	}
+}
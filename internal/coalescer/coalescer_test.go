@@ -0,0 +1,127 @@
+package coalescer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/watcher"
+)
+
+func Test_collapse(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		want    []string
+		wantSub map[string][]string
+	}{
+		{
+			"unrelated",
+			[]string{"/home/user", "/tmp"},
+			[]string{"/home/user", "/tmp"},
+			map[string][]string{},
+		},
+		{
+			"descendants",
+			[]string{"/home/user/build/a/b", "/home/user/build", "/home/user/build/a"},
+			[]string{"/home/user/build"},
+			map[string][]string{
+				"/home/user/build": {"/home/user/build/a", "/home/user/build/a/b"},
+			},
+		},
+		{
+			"similar_prefix",
+			[]string{"/a", "/a-b", "/a/b"},
+			[]string{"/a", "/a-b"},
+			map[string][]string{
+				"/a": {"/a/b"},
+			},
+		},
+		{
+			"root",
+			[]string{"/", "/etc"},
+			[]string{"/"},
+			map[string][]string{
+				"/": {"/etc"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending := make(map[string]watcher.FileChange)
+			for _, path := range tt.paths {
+				pending[path] = watcher.FileChange{FolderPath: path}
+			}
+
+			got := collapse(pending)
+
+			gotPaths := []string{}
+			for _, change := range got {
+				gotPaths = append(gotPaths, change.FolderPath)
+				if want := tt.wantSub[change.FolderPath]; !reflect.DeepEqual(change.Descendants, want) {
+					t.Errorf("collapse() descendants of %s = %v, want %v",
+						change.FolderPath, change.Descendants, want)
+				}
+			}
+			if !reflect.DeepEqual(gotPaths, tt.want) {
+				t.Errorf("collapse() = %v, want %v", gotPaths, tt.want)
+			}
+		})
+	}
+}
+
+func TestStart(t *testing.T) {
+	in := make(chan watcher.FileChange)
+	out := make(chan watcher.FileChange)
+	go Start(10*time.Millisecond, in, out, nil)
+
+	in <- watcher.FileChange{FolderPath: "/home/user/build/a"}
+	in <- watcher.FileChange{FolderPath: "/home/user/build"}
+	in <- watcher.FileChange{FolderPath: "/home/user/build"}
+
+	select {
+	case change := <-out:
+		if change.FolderPath != "/home/user/build" ||
+			!reflect.DeepEqual(change.Descendants, []string{"/home/user/build/a"}) {
+			t.Errorf("Start() sent %v", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start() didn't send the merged change")
+	}
+	close(in)
+}
+
+func TestStart_Closed(t *testing.T) {
+	in := make(chan watcher.FileChange)
+	out := make(chan watcher.FileChange)
+	go Start(time.Hour, in, out, nil)
+
+	// the pending changes are sent when the watcher is closed
+	in <- watcher.FileChange{FolderPath: "/home/user/a"}
+	close(in)
+	select {
+	case change := <-out:
+		if change.FolderPath != "/home/user/a" {
+			t.Errorf("Start() sent %v", change)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start() dropped the pending change")
+	}
+
+	// nothing is sent once the receiver is done
+	in = make(chan watcher.FileChange)
+	done := make(chan struct{})
+	returned := make(chan struct{})
+	go func() {
+		Start(time.Hour, in, out, done)
+		close(returned)
+	}()
+	in <- watcher.FileChange{FolderPath: "/home/user/a"}
+	close(in)
+	close(done)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Start() didn't return after done was closed")
+	}
+}
@@ -2,38 +2,153 @@ package config
 
 import (
 	"encoding/json"
-	"log"
+	"io/ioutil"
+	"log/slog"
 	"os"
-	"regexp"
-	"strings"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"time"
 
+	"github.com/ozeidan/gosearch/internal/ignore"
 	"github.com/pkg/errors"
 )
 
 type serverConfig struct {
-	PrefixFilters     []string `json:"prefix_filters"`
-	SubstringFilters  []string `json:"substring_filters"`
-	RegexFilters      []string `json:"regex_filters"`
-	IgnoreHiddenFiles bool     `json:"ignore_hidden_files"`
-	StdoutLogs        bool     `json:"print_logs"`
-	FileLogs          bool     `json:"file_logs"`
-	HomeOnly          bool     `json:"home_only"`
+	PrefixFilters        []string     `json:"prefix_filters"`
+	SubstringFilters     []string     `json:"substring_filters"`
+	RegexFilters         []string     `json:"regex_filters"`
+	IgnorePatterns       []string     `json:"ignore_patterns"`
+	IncludePatterns      []string     `json:"include_patterns"`
+	IgnoreHiddenFiles    bool         `json:"ignore_hidden_files"`
+	UseIgnoreFiles       bool         `json:"use_ignore_files"`
+	StdoutLogs           bool         `json:"print_logs"`
+	FileLogs             bool         `json:"file_logs"`
+	LogLevel             string       `json:"log_level"`
+	LogFormat            string       `json:"log_format"`
+	LogMaxSizeMB         int          `json:"log_max_size_mb"`
+	LogMaxFiles          int          `json:"log_max_files"`
+	LogJournald          bool         `json:"log_journald"`
+	RedactQueries        bool         `json:"redact_queries"`
+	HomeOnly             bool         `json:"home_only"`
+	CoalesceWindowMs     int          `json:"coalesce_window_ms"`
+	Watcher              string       `json:"watcher"`
+	RescanIntervalSec    int          `json:"rescan_interval_s"`
+	WalkParallelism      int          `json:"walk_parallelism"`
+	PathCacheSize        int          `json:"path_cache_size"`
+	TrigramIndex         bool         `json:"trigram_index"`
+	CaseInsensitiveIndex bool         `json:"case_insensitive_index"`
+	MetricsListen        string       `json:"metrics_listen"`
+	Roots                []rootConfig `json:"roots"`
 }
 
 const AppName = "gosearch"
-const configPath = "/etc/gosearch/config" // TODO: XDG_CONFIG_DIRS?
 
-var config = serverConfig{
-	[]string{}, []string{}, []string{},
-	false, true, false, false,
+// Version is the version of the program, it is set when building
+var Version = "dev"
+
+const systemConfigPath = "/etc/gosearch/config"
+
+var defaultConfig = serverConfig{
+	PrefixFilters:     []string{},
+	SubstringFilters:  []string{},
+	RegexFilters:      []string{},
+	IgnorePatterns:    []string{},
+	IncludePatterns:   []string{},
+	StdoutLogs:        true,
+	LogLevel:          "info",
+	LogFormat:         "text",
+	LogMaxSizeMB:      10,
+	LogMaxFiles:       5,
+	RedactQueries:     true,
+	CoalesceWindowMs:  100,
+	Watcher:           "auto",
+	RescanIntervalSec: 60,
+	Roots:             []rootConfig{},
+}
+
+// configState holds a parsed configuration, it is replaced
+// as a whole when the configuration is reloaded
+type configState struct {
+	config serverConfig
+	roots  []root
+}
+
+var state atomic.Value
+
+func init() {
+	state.Store(newConfigState(defaultConfig))
+}
+
+func current() *configState {
+	return state.Load().(*configState)
+}
+
+func newConfigState(c serverConfig) *configState {
+	return &configState{
+		config: c,
+		roots:  parseRoots(c),
+	}
+}
+
+var userMode bool
+
+// EnableUserMode switches to running as an unprivileged user,
+// configuration, logs and the index roots are then taken
+// from the user's XDG directories and home directory
+func EnableUserMode() {
+	userMode = true
+}
+
+// UserMode returns whether the server runs in user mode
+func UserMode() bool {
+	return userMode
+}
+
+// ConfigPath returns the path of the configuration file
+func ConfigPath() string {
+	if userMode {
+		return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), AppName, "config")
+	}
+	return systemConfigPath
+}
+
+// LogDirectory returns the directory the log files are written to
+func LogDirectory() string {
+	if userMode {
+		return filepath.Join(xdgDir("XDG_STATE_HOME", ".local/state"), AppName)
+	}
+	return filepath.Join("/var/log", AppName)
+}
+
+// xdgDir returns the directory set in the XDG environment variable env,
+// or fallback inside of the home directory if it isn't set
+func xdgDir(env, fallback string) string {
+	if dir := os.Getenv(env); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(homeDir(), fallback)
 }
 
-var regexFilters []*regexp.Regexp
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "/"
+	}
+	return home
+}
 
 // ParseConfig initializes the configuration of the program
 // by reading and parsing the config file
 func ParseConfig() error {
-	file, err := os.Open(configPath)
+	c := defaultConfig
+	err := readConfig(&c)
+	state.Store(newConfigState(c))
+	return err
+}
+
+func readConfig(c *serverConfig) error {
+	data, err := ioutil.ReadFile(ConfigPath())
 
 	if os.IsNotExist(err) {
 		err = createConfigStub()
@@ -45,21 +160,15 @@ func ParseConfig() error {
 		return err
 	}
 
-	err = json.NewDecoder(file).Decode(&config)
-	if err != nil {
-		return err
-	}
-
-	parseFilters()
-	return nil
+	return json.Unmarshal(data, c)
 }
 
 func createConfigStub() error {
-	err := os.Mkdir("/etc/gosearch", os.ModePerm)
-	if err != nil && !os.IsExist(err) {
+	err := os.MkdirAll(filepath.Dir(ConfigPath()), os.ModePerm)
+	if err != nil {
 		return errors.Wrap(err, "can't create config directory")
 	}
-	f, err := os.Create(configPath)
+	f, err := os.Create(ConfigPath())
 	if err != nil {
 		return errors.Wrap(err, "can't create config file")
 	}
@@ -67,7 +176,7 @@ func createConfigStub() error {
 	enc := json.NewEncoder(f)
 	enc.SetIndent("", "    ")
 
-	err = enc.Encode(&config)
+	err = enc.Encode(&defaultConfig)
 	if err != nil {
 		return err
 	}
@@ -75,51 +184,114 @@ func createConfigStub() error {
 	return nil
 }
 
-func parseFilters() {
-	for _, filterString := range config.RegexFilters {
-		r, err := regexp.Compile(filterString)
-		if err != nil {
-			log.Println("failed to parse regex filter:", err)
+// parsePatterns compiles gitignore patterns that are relative to base,
+// it returns nil if there are no valid patterns
+func parsePatterns(base string, patterns []string) *ignore.Rules {
+	valid := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if err := ignore.Check(pattern); err != nil {
+			slog.Warn("ignoring invalid pattern", "err", err)
+			continue
 		}
-		regexFilters = append(regexFilters, r)
+		valid = append(valid, pattern)
+	}
+	if len(valid) == 0 {
+		return nil
 	}
+	rules, _ := ignore.Parse(base, valid)
+	return rules
 }
 
-// IsPathFiltered determines returns whether the given path is filtered
-// by the user's configuration
-func IsPathFiltered(path string) bool {
-	if config.HomeOnly &&
-		!strings.HasPrefix(path, "/home") &&
-		path != "/" {
-		return true
+// CoalesceWindow returns the time window in which file change events
+// are collected and merged before the affected directories are refreshed,
+// a window of zero disables coalescing
+func CoalesceWindow() time.Duration {
+	windowMs := current().config.CoalesceWindowMs
+	if windowMs < 0 {
+		return 0
 	}
+	return time.Duration(windowMs) * time.Millisecond
+}
 
-	for _, prefixFilter := range config.PrefixFilters {
-		if strings.HasPrefix(path, prefixFilter) {
-			return true
-		}
+// WatcherBackend returns the name of the configured backend for watching
+// file changes, "auto" selects the best backend supported by the system
+func WatcherBackend() string {
+	backend := current().config.Watcher
+	if backend == "" {
+		return "auto"
 	}
+	return backend
+}
 
-	for _, substringFilter := range config.SubstringFilters {
-		if strings.Contains(path, substringFilter) {
-			return true
-		}
+// RescanInterval returns the interval in which directories are rescanned
+// when they can't be watched for changes
+func RescanInterval() time.Duration {
+	intervalSec := current().config.RescanIntervalSec
+	if intervalSec <= 0 {
+		return time.Minute
 	}
+	return time.Duration(intervalSec) * time.Second
+}
 
-	for _, r := range regexFilters {
-		if r.MatchString(path) {
-			return true
-		}
+// WalkParallelism returns the number of directories that are read
+// concurrently while building the initial index,
+// it defaults to the number of CPUs
+func WalkParallelism() int {
+	parallelism := current().config.WalkParallelism
+	if parallelism <= 0 {
+		return runtime.NumCPU()
 	}
+	return parallelism
+}
 
-	if config.IgnoreHiddenFiles {
-		parts := strings.Split(path, "/")
-		for _, part := range parts {
-			if len(part) > 0 && part[0] == '.' {
-				return true
-			}
-		}
+// TrigramIndex returns whether substring and regex searches
+// use a trigram index of the names
+func TrigramIndex() bool {
+	return current().config.TrigramIndex
+}
+
+// CaseInsensitiveIndex returns whether case insensitive searches
+// use an index of the case folded names, it is never built unless
+// this is set
+func CaseInsensitiveIndex() bool {
+	return current().config.CaseInsensitiveIndex
+}
+
+// PathCacheSize returns the number of directory paths that are kept
+// to answer queries, zero disables the cache
+func PathCacheSize() int {
+	if size := current().config.PathCacheSize; size > 0 {
+		return size
 	}
+	return 0
+}
+
+// JSON returns the configuration that is in effect, encoded as JSON
+func JSON() (json.RawMessage, error) {
+	return json.Marshal(current().config)
+}
+
+// MetricsAddress returns the address that metrics are served on,
+// metrics are disabled if it's empty
+func MetricsAddress() string {
+	return current().config.MetricsListen
+}
+
+// UseIgnoreFiles returns whether .gitignore and .ignore files
+// inside of the indexed directories are honoured
+func UseIgnoreFiles() bool {
+	return current().config.UseIgnoreFiles
+}
+
+// IsPathFiltered determines returns whether the given directory
+// is filtered by the user's configuration
+func IsPathFiltered(path string) bool {
+	return IsEntryFiltered(path, true)
+}
 
-	return false
+// IsEntryFiltered returns whether the file or directory at path
+// is filtered by the user's configuration
+func IsEntryFiltered(path string, isDir bool) bool {
+	_, filtered := PathFilter(path, isDir)
+	return filtered
 }
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldHandler sends records to journald using its native protocol,
+// attributes are sent as journal fields and appended to the message
+type journaldHandler struct {
+	mutex   *sync.Mutex
+	conn    *net.UnixConn
+	options *slog.HandlerOptions
+	attrs   []slog.Attr
+	groups  []string
+}
+
+func newJournaldHandler(options *slog.HandlerOptions) (*journaldHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil,
+		&net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldHandler{mutex: new(sync.Mutex), conn: conn, options: options}, nil
+}
+
+func (h *journaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.options.Level.Level()
+}
+
+func (h *journaldHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr{}, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, h.qualify(a))
+		return true
+	})
+
+	message := journaldMessage(r.Level, r.Message, attrs)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := h.conn.Write(message)
+	return err
+}
+
+func (h *journaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handler := *h
+	handler.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		handler.attrs = append(handler.attrs, h.qualify(a))
+	}
+	return &handler
+}
+
+func (h *journaldHandler) WithGroup(name string) slog.Handler {
+	handler := *h
+	handler.groups = append(append([]string{}, h.groups...), name)
+	return &handler
+}
+
+// qualify prefixes the key of a with the open groups
+// and replaces its value if necessary
+func (h *journaldHandler) qualify(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	if h.options.ReplaceAttr != nil {
+		a = h.options.ReplaceAttr(h.groups, a)
+	}
+	if len(h.groups) > 0 {
+		a.Key = strings.Join(h.groups, "_") + "_" + a.Key
+	}
+	return a
+}
+
+// journaldPriority maps a level to a syslog priority
+func journaldPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// journaldMessage encodes a record in the native journal protocol
+func journaldMessage(level slog.Level, msg string, attrs []slog.Attr) []byte {
+	var text strings.Builder
+	text.WriteString(msg)
+	for _, a := range attrs {
+		fmt.Fprintf(&text, " %s=%s", a.Key, quoteValue(a.Value.String()))
+	}
+
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", text.String())
+	writeJournalField(&b, "PRIORITY", fmt.Sprint(journaldPriority(level)))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", AppName)
+	for _, a := range attrs {
+		writeJournalField(&b, journalFieldName(a.Key), a.Value.String())
+	}
+	return b.Bytes()
+}
+
+func quoteValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return fmt.Sprintf("%q", value)
+	}
+	return value
+}
+
+// journalFieldName converts key to a valid journal field name,
+// which consists of upper case letters, digits and underscores
+// and doesn't start with an underscore or a digit
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	switch name {
+	case "":
+		return "ATTR"
+	case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+		return "ATTR_" + name
+	}
+	return name
+}
+
+func writeJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	// values containing newlines are prefixed with their length
+	b.WriteString(name)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
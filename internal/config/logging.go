@@ -1,22 +1,41 @@
 package config
 
 import (
-	"fmt"
+	"context"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// logLevel is the level of the installed logger,
+// it is updated when the configuration is reloaded
+var logLevel = new(slog.LevelVar)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+var logFormats = []string{"text", "json"}
+
+// SetupLogging installs the default logger, which writes to the outputs
+// selected in the configuration with the configured level and format
 func SetupLogging() error {
+	config := current().config
+	logLevel.Set(parseLogLevel(config.LogLevel))
+
 	var writers []io.Writer
 	if config.FileLogs {
-		file, err := setupLogFiles()
+		file, err := setupLogFile(config)
 		if err != nil {
 			return err
 		}
-
 		writers = append(writers, file)
 	}
 
@@ -24,48 +43,118 @@ func SetupLogging() error {
 		writers = append(writers, os.Stdout)
 	}
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(io.MultiWriter(writers...))
+	options := &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactQuery,
+	}
+
+	var handlers []slog.Handler
+	if len(writers) > 0 {
+		output := io.MultiWriter(writers...)
+		if config.LogFormat == "json" {
+			handlers = append(handlers, slog.NewJSONHandler(output, options))
+		} else {
+			handlers = append(handlers, slog.NewTextHandler(output, options))
+		}
+	}
+
+	var journaldErr error
+	if config.LogJournald {
+		var handler slog.Handler
+		handler, journaldErr = newJournaldHandler(options)
+		if journaldErr == nil {
+			handlers = append(handlers, handler)
+		}
+	}
+
+	switch len(handlers) {
+	case 0:
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, options)))
+	case 1:
+		slog.SetDefault(slog.New(handlers[0]))
+	default:
+		slog.SetDefault(slog.New(teeHandler(handlers)))
+	}
+
+	if journaldErr != nil {
+		slog.Warn("couldn't connect to journald", "err", journaldErr)
+	}
 	return nil
 }
 
-func setupLogFiles() (*os.File, error) {
-	logDirectory := fmt.Sprintf("/var/log/%s", AppName)
-	if _, err := os.Stat(logDirectory); os.IsNotExist(err) {
-		err := os.Mkdir(logDirectory, os.ModePerm)
-		if err != nil {
-			return nil, errors.Wrap(
-				err,
-				"couldn't create logging directory",
-			)
-		}
+func parseLogLevel(level string) slog.Level {
+	if l, ok := logLevels[strings.ToLower(level)]; ok {
+		return l
+	}
+	return slog.LevelInfo
+}
+
+// redactQuery hides the values of query attributes if the configuration
+// asks for it, so the logs don't reveal what users search for
+func redactQuery(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == "query" && current().config.RedactQueries {
+		return slog.String("query", "[redacted]")
+	}
+	return a
+}
+
+func setupLogFile(config serverConfig) (io.Writer, error) {
+	logDirectory := LogDirectory()
+	if err := os.MkdirAll(logDirectory, os.ModePerm); err != nil {
+		return nil, errors.Wrap(
+			err,
+			"couldn't create logging directory",
+		)
 	}
 
-	logFilePath := fmt.Sprintf("%s/default", logDirectory)
-	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := openRotatingFile(filepath.Join(logDirectory, "default"),
+		int64(config.LogMaxSizeMB)*1024*1024, config.LogMaxFiles)
 	if err != nil {
 		return nil, errors.Wrap(
 			err,
 			"couldn't open logfile",
 		)
 	}
+	return file, nil
+}
 
-	// log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	// var output io.Writer
-	// switch {
-	// case stdoutLogs || fileLogs:
-	// 	output = io.MultiWriter(os.Stdout, file)
-	// case fileLogs:
-	// 	output = os.Stdout
-	// case stdoutLogs:
-	// 	output = file
-	// default:
-	// }
-	// if printLogs {
-	// 	log.SetOutput(io.MultiWriter(os.Stdout, file))
-	// } else {
-	// 	log.SetOutput(file)
-	// }
+// teeHandler passes records on to all of its handlers
+type teeHandler []slog.Handler
 
-	return file, nil
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var firstErr error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
 }
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "default")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"default", "gggg\n"},
+		{"default.1", "eeee\nffff\n"},
+		{"default.2", "cccc\ndddd\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ioutil.ReadFile(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("%s contains %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "default.3")); !os.IsNotExist(err) {
+		t.Errorf("more than 2 rotated files are kept")
+	}
+}
+
+func TestRotatingFile_RenameFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "default")
+	f, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// the log file can't be renamed to a directory that isn't empty
+	rotated := filepath.Join(dir, "default.1", "file")
+	if err := os.MkdirAll(rotated, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("aaaaaaaa\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("bbbb\n")); err == nil {
+		t.Fatal("the file was rotated onto a directory")
+	}
+
+	// the file stays usable after the rotation failed
+	if err := os.RemoveAll(filepath.Dir(rotated)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("cccc\n")); err != nil {
+		t.Fatalf("writing after a failed rotation failed: %v", err)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "cccc\n" {
+		t.Errorf("default contains %q, want %q", got, "cccc\n")
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	defer state.Store(current())
+
+	tests := []struct {
+		name   string
+		redact bool
+		want   string
+	}{
+		{"redacted", true, "query=[redacted]"},
+		{"not_redacted", false, "query=secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig
+			c.RedactQueries = tt.redact
+			state.Store(newConfigState(c))
+
+			var b bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&b,
+				&slog.HandlerOptions{ReplaceAttr: redactQuery}))
+			logger.Info("received query", "query", "secret")
+
+			if !strings.Contains(b.String(), tt.want) {
+				t.Errorf("log line %q doesn't contain %q", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJournaldMessage(t *testing.T) {
+	got := string(journaldMessage(slog.LevelWarn, "couldn't read directory", []slog.Attr{
+		slog.String("path", "/home/user/my dir"),
+		slog.String("err", "first\nsecond"),
+		slog.Int("message", 1),
+	}))
+
+	want := "MESSAGE=couldn't read directory path=\"/home/user/my dir\" " +
+		"err=\"first\\nsecond\" message=1\n" +
+		"PRIORITY=4\n" +
+		"SYSLOG_IDENTIFIER=gosearch\n" +
+		"PATH=/home/user/my dir\n" +
+		"ERR\n\x0c\x00\x00\x00\x00\x00\x00\x00first\nsecond\n" +
+		"ATTR_MESSAGE=1\n"
+	if got != want {
+		t.Errorf("journaldMessage() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,281 @@
+package config
+
+import (
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// matcher is the compiled form of a set of filters
+// prefixes are matched with a trie and substrings with an Aho-Corasick
+// automaton, regexes are kept separately because an alternation of them
+// loses the literal and anchor optimizations of the single regexes
+// the prefix and substring states can be advanced component by component,
+// so that entries are matched without scanning their whole path again
+type matcher struct {
+	prefixes     byteTrie
+	substrings   byteTrie
+	regexes      []*regexp.Regexp
+	ignoreHidden bool
+}
+
+// deadState is the prefix state once no prefix can match anymore
+const deadState = -1
+
+// byteTrie is a trie with a dense transition table, which is
+// turned into an Aho-Corasick automaton by linkFailures
+type byteTrie struct {
+	next   [][256]int32
+	output []bool
+}
+
+func newByteTrie(words []string) byteTrie {
+	t := byteTrie{next: make([][256]int32, 1), output: make([]bool, 1)}
+	for _, word := range words {
+		state := int32(0)
+		for i := 0; i < len(word); i++ {
+			c := word[i]
+			if t.next[state][c] == 0 {
+				t.next = append(t.next, [256]int32{})
+				t.output = append(t.output, false)
+				t.next[state][c] = int32(len(t.next) - 1)
+			}
+			state = t.next[state][c]
+		}
+		t.output[state] = true
+	}
+	return t
+}
+
+// linkFailures completes the transition table, so that it follows
+// the longest suffix that is still a prefix of a word on mismatches
+func (t *byteTrie) linkFailures() {
+	fail := make([]int32, len(t.next))
+	var queue []int32
+
+	for c := 0; c < 256; c++ {
+		if child := t.next[0][c]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		t.output[state] = t.output[state] || t.output[fail[state]]
+
+		for c := 0; c < 256; c++ {
+			child := t.next[state][c]
+			if child == 0 {
+				t.next[state][c] = t.next[fail[state]][c]
+				continue
+			}
+			fail[child] = t.next[fail[state]][c]
+			queue = append(queue, child)
+		}
+	}
+}
+
+func newMatcher(prefixes, substrings []string, regexes []string, ignoreHidden bool) *matcher {
+	m := &matcher{
+		prefixes:     newByteTrie(prefixes),
+		substrings:   newByteTrie(substrings),
+		ignoreHidden: ignoreHidden,
+	}
+	m.substrings.linkFailures()
+
+	for _, r := range regexes {
+		m.regexes = append(m.regexes, regexp.MustCompile(r))
+	}
+	return m
+}
+
+// validRegexes returns the regexes that compile, skipping invalid ones
+func validRegexes(regexes []string) []string {
+	valid := make([]string, 0, len(regexes))
+	for _, r := range regexes {
+		if _, err := regexp.Compile(r); err != nil {
+			slog.Warn("ignoring invalid regex filter", "err", err)
+			continue
+		}
+		valid = append(valid, r)
+	}
+	return valid
+}
+
+// matchState is the state of a matcher after matching a part of a path
+type matchState struct {
+	prefix    int32
+	substring int32
+	// matched is set once a prefix, substring or hidden component matched,
+	// which then also matches all paths below
+	matched bool
+}
+
+// start matches path from its beginning
+func (m *matcher) start(path string) matchState {
+	s := matchState{
+		matched: m.prefixes.output[0] || m.substrings.output[0],
+	}
+	path = strings.TrimSuffix(path, "/")
+	for len(path) > 0 && !s.matched {
+		end := strings.IndexByte(path[1:], '/') + 1
+		if end == 0 {
+			end = len(path)
+		}
+		s = m.advance(s, path[:end])
+		path = path[end:]
+	}
+	return s
+}
+
+// advance continues matching with component, which is
+// the name of an entry including the leading slash
+func (m *matcher) advance(s matchState, component string) matchState {
+	if s.matched {
+		return s
+	}
+	if m.ignoreHidden && len(component) > 1 && component[1] == '.' {
+		s.matched = true
+		return s
+	}
+
+	for i := 0; i < len(component); i++ {
+		c := component[i]
+		if s.prefix != deadState {
+			s.prefix = m.prefixes.next[s.prefix][c]
+			if s.prefix == 0 {
+				s.prefix = deadState
+			} else if m.prefixes.output[s.prefix] {
+				s.matched = true
+				return s
+			}
+		}
+		s.substring = m.substrings.next[s.substring][c]
+		if m.substrings.output[s.substring] {
+			s.matched = true
+			return s
+		}
+	}
+	return s
+}
+
+// match returns whether the path, whose components lead to s, is filtered
+func (m *matcher) match(s matchState, path string) bool {
+	if s.matched {
+		return true
+	}
+	for _, r := range m.regexes {
+		if r.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter is the state of the filters after matching a directory,
+// the entries of the directory are matched by extending it with their names
+type Filter struct {
+	state *configState
+	// the closest root that contains the path, nil if there is none
+	root  *root
+	match matchState
+	// rootsBelow is set when the path is a root or a parent of one
+	rootsBelow bool
+	// included is set when an include pattern matches
+	// the path or one of its parents
+	included bool
+	// ignored is set when the ignore patterns of the root
+	// match the path or one of its parents
+	ignored bool
+}
+
+// Included returns whether the path is included by an include pattern,
+// which takes precedence over all exclusions
+func (f Filter) Included() bool {
+	return f.included
+}
+
+// PathFilter matches the file or directory at path against the filters,
+// it returns whether path is filtered and the state that the entries
+// below path can be matched with
+func PathFilter(path string, isDir bool) (Filter, bool) {
+	return current().pathFilter(path, isDir)
+}
+
+func (s *configState) pathFilter(path string, isDir bool) (Filter, bool) {
+	f := Filter{state: s, rootsBelow: s.isRootParent(path)}
+	if r := s.rootOf(path); r != nil {
+		f.root = r
+		f.match = r.matcher.start(path)
+		f.included = r.isIncluded(path, isDir)
+		f.ignored = r.ignoreRules.IgnoresParent(path)
+	}
+	return f.filter(path, isDir)
+}
+
+// Child matches the entry at path, which has to be inside of the
+// directory of f, it returns whether the entry is filtered and
+// the state that the entries below it can be matched with
+func (f Filter) Child(path string, isDir bool) (Filter, bool) {
+	if f.rootsBelow {
+		// a root may start at path, the state can't be extended
+		return f.state.pathFilter(path, isDir)
+	}
+	if f.root == nil {
+		return f, true
+	}
+
+	slash := strings.LastIndexByte(path, '/')
+	f.match = f.root.matcher.advance(f.match, path[slash:])
+	if !f.included && f.root.includeRules != nil {
+		_, f.included = f.root.includeRules.Match(path, isDir)
+	}
+	return f.filter(path, isDir)
+}
+
+// filter matches the entry at path against the ignore patterns,
+// f is the state of its parent directory, like in git the entries
+// below an ignored directory are ignored as well
+func (f Filter) filter(path string, isDir bool) (Filter, bool) {
+	if f.root != nil && !f.ignored {
+		_, f.ignored = f.root.ignoreRules.Match(path, isDir)
+	}
+	return f, f.filtered(path, isDir)
+}
+
+func (f Filter) filtered(path string, isDir bool) bool {
+	// the roots and their parent directories have to be
+	// kept in the index tree to reach the roots
+	if f.rootsBelow || f.included {
+		return false
+	}
+	if f.root == nil {
+		return true
+	}
+
+	excluded := f.ignored || f.root.matcher.match(f.match, path)
+	// excluded directories are still walked if
+	// an include pattern may match below them
+	if excluded && isDir && f.root.includeRules.CouldMatchBelow(path) {
+		return false
+	}
+	return excluded
+}
+
+// isIncluded returns whether an include pattern of r
+// matches path or one of its parents inside of r
+func (r *root) isIncluded(path string, isDir bool) bool {
+	if r.includeRules == nil {
+		return false
+	}
+	// the deepest matching pattern decides
+	for ; isParentOrSelf(r.path, path) && path != r.path; path = filepath.Dir(path) {
+		if matched, included := r.includeRules.Match(path, isDir); matched {
+			return included
+		}
+		isDir = true
+	}
+	return false
+}
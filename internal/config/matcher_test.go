@@ -0,0 +1,322 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// naiveMatch is the straightforward implementation of the filters,
+// which the compiled matcher has to agree with
+func naiveMatch(c serverConfig, path string) bool {
+	for _, prefix := range c.PrefixFilters {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	for _, substring := range c.SubstringFilters {
+		if strings.Contains(path, substring) {
+			return true
+		}
+	}
+	for _, r := range c.RegexFilters {
+		if regexp.MustCompile(r).MatchString(path) {
+			return true
+		}
+	}
+	if c.IgnoreHiddenFiles {
+		for _, part := range strings.Split(path, "/") {
+			if len(part) > 0 && part[0] == '.' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var testFilterConfig = serverConfig{
+	PrefixFilters:     []string{"/proc", "/sys/", "/home/user/tmp", "/var/lib/docker"},
+	SubstringFilters:  []string{"node_modules", "__pycache__", "cache/", "abab"},
+	RegexFilters:      []string{`\.o$`, `^/tmp/[0-9]+$`},
+	IgnoreHiddenFiles: true,
+	Roots:             []rootConfig{{Path: "/"}},
+}
+
+var testFilterPaths = []string{
+	"/",
+	"/proc",
+	"/process",
+	"/proc/1/status",
+	"/sys",
+	"/sys/kernel",
+	"/home/user/tmp",
+	"/home/user/tmpfile",
+	"/home/user/temp",
+	"/home/user/web/node_modules/x/index.js",
+	"/home/user/node_module",
+	"/home/user/src/__pycache__",
+	"/home/user/cache",
+	"/home/user/cache/x",
+	"/home/user/aabab",
+	"/home/user/abaab",
+	"/home/user/main.o",
+	"/home/user/main.go",
+	"/tmp/123",
+	"/tmp/123/x",
+	"/tmp/abc",
+	"/home/user/.config",
+	"/home/user/.config/app/settings",
+	"/home/user/a.b",
+	"/var/lib/docker/overlay2",
+	"/var/lib/dock",
+}
+
+func TestPathFilter(t *testing.T) {
+	defer state.Store(current())
+	state.Store(newConfigState(testFilterConfig))
+
+	for _, path := range testFilterPaths {
+		t.Run(path, func(t *testing.T) {
+			want := naiveMatch(testFilterConfig, path)
+			if _, got := PathFilter(path, true); got != want {
+				t.Errorf("PathFilter(%q) = %v, want %v", path, got, want)
+			}
+		})
+	}
+}
+
+func TestFilter_Child(t *testing.T) {
+	defer state.Store(current())
+	state.Store(newConfigState(testFilterConfig))
+
+	for _, path := range testFilterPaths {
+		t.Run(path, func(t *testing.T) {
+			// extend the state component by component,
+			// descending even into filtered directories
+			filter, filtered := PathFilter("/", true)
+			current := ""
+			for _, part := range pathToParts(path) {
+				current += "/" + part
+				filter, filtered = filter.Child(current, true)
+			}
+			if want := naiveMatch(testFilterConfig, path); filtered != want {
+				t.Errorf("Child(%q) = %v, want %v", path, filtered, want)
+			}
+		})
+	}
+}
+
+func TestFilter_Roots(t *testing.T) {
+	defer state.Store(current())
+	state.Store(newConfigState(serverConfig{
+		SubstringFilters: []string{"skip"},
+		Roots: []rootConfig{
+			{Path: "/home/skip"},
+			{Path: "/srv/data", PrefixFilters: []string{"/srv/data/tmp"}},
+		},
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		filtered bool
+	}{
+		{"parent_of_root", "/srv", false},
+		{"root", "/srv/data", false},
+		{"outside_of_roots", "/etc", true},
+		{"inside_of_root", "/srv/data/file", false},
+		{"root_filter", "/srv/data/tmp/file", true},
+		{"global_filter", "/srv/data/skip", true},
+		{"filtered_root", "/home/skip", false},
+		{"inside_of_filtered_root", "/home/skip/file", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEntryFiltered(tt.path, false); got != tt.filtered {
+				t.Errorf("IsEntryFiltered(%q) = %v, want %v", tt.path, got, tt.filtered)
+			}
+
+			parent, _ := PathFilter("/", true)
+			parts := pathToParts(tt.path)
+			current := ""
+			for _, part := range parts[:len(parts)-1] {
+				current += "/" + part
+				parent, _ = parent.Child(current, true)
+			}
+			if _, got := parent.Child(tt.path, false); got != tt.filtered {
+				t.Errorf("Child(%q) = %v, want %v", tt.path, got, tt.filtered)
+			}
+		})
+	}
+}
+
+func TestFilter_Include(t *testing.T) {
+	defer state.Store(current())
+	state.Store(newConfigState(serverConfig{
+		IgnoreHiddenFiles: true,
+		IgnorePatterns:    []string{"*.log"},
+		IncludePatterns:   []string{"/.config", ".local/bin/", "src/**/important.log"},
+		Roots:             []rootConfig{{Path: "/home/user"}},
+	}))
+
+	tests := []struct {
+		name     string
+		path     string
+		isDir    bool
+		filtered bool
+	}{
+		{"included_directory", "/home/user/.config", true, false},
+		{"below_included_directory", "/home/user/.config/app/.settings", false, false},
+		{"parent_of_included_directory", "/home/user/.local", true, false},
+		{"included_nested_directory", "/home/user/.local/bin/tool", false, false},
+		{"sibling_of_included_directory", "/home/user/.local/share", true, true},
+		{"directory_only_include_on_file", "/home/user/.local/bin", false, true},
+		{"excluded_hidden", "/home/user/.cache", true, true},
+		{"excluded_pattern", "/home/user/debug.log", false, true},
+		{"included_pattern", "/home/user/src/.x/important.log", false, false},
+		{"parent_of_included_pattern", "/home/user/src/.x", true, false},
+		{"anchored_include_elsewhere", "/home/user/lib/.config", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEntryFiltered(tt.path, tt.isDir); got != tt.filtered {
+				t.Errorf("IsEntryFiltered(%q) = %v, want %v", tt.path, got, tt.filtered)
+			}
+
+			parent, _ := PathFilter("/home/user", true)
+			parts := pathToParts(tt.path)
+			current := "/home/user"
+			for _, part := range parts[2 : len(parts)-1] {
+				current += "/" + part
+				parent, _ = parent.Child(current, true)
+			}
+			if _, got := parent.Child(tt.path, tt.isDir); got != tt.filtered {
+				t.Errorf("Child(%q) = %v, want %v", tt.path, got, tt.filtered)
+			}
+		})
+	}
+}
+
+func TestFilter_FloatingInclude(t *testing.T) {
+	defer state.Store(current())
+	state.Store(newConfigState(serverConfig{
+		IgnoreHiddenFiles: true,
+		IncludePatterns:   []string{"*.conf"},
+		Roots:             []rootConfig{{Path: "/home/user"}},
+	}))
+
+	// floating patterns may match anywhere, so excluded
+	// directories are still walked, but not their other files
+	if IsEntryFiltered("/home/user/.cache/a", true) {
+		t.Errorf("excluded directory is pruned")
+	}
+	if IsEntryFiltered("/home/user/.cache/a/app.conf", false) {
+		t.Errorf("included file is filtered")
+	}
+	if !IsEntryFiltered("/home/user/.cache/a/data", false) {
+		t.Errorf("excluded file is not filtered")
+	}
+}
+
+func TestFilter_IgnoredDirectory(t *testing.T) {
+	defer state.Store(current())
+	state.Store(newConfigState(serverConfig{
+		IgnorePatterns:  []string{"node_modules/", "build/", "!build/keep.o"},
+		IncludePatterns: []string{"/a/build/app.conf"},
+		Roots:           []rootConfig{{Path: "/r"}},
+	}))
+
+	// like in git, the entries below an ignored directory
+	// are ignored as well and can't be re-included
+	tests := []struct {
+		name     string
+		path     string
+		isDir    bool
+		filtered bool
+	}{
+		{"ignored_directory", "/r/node_modules", true, true},
+		{"child", "/r/node_modules/pkg", true, true},
+		{"descendant", "/r/node_modules/pkg/index.js", false, true},
+		{"nested_descendant", "/r/a/build/x.o", false, true},
+		{"negated_descendant", "/r/a/build/keep.o", false, true},
+		{"file_with_directory_name", "/r/a/build", false, false},
+		{"similar_directory", "/r/a/builder/x.o", false, false},
+		{"included_descendant", "/r/a/build/app.conf", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEntryFiltered(tt.path, tt.isDir); got != tt.filtered {
+				t.Errorf("IsEntryFiltered(%q) = %v, want %v", tt.path, got, tt.filtered)
+			}
+
+			parent, _ := PathFilter("/r", true)
+			parts := pathToParts(tt.path)
+			current := "/r"
+			for _, part := range parts[1 : len(parts)-1] {
+				current += "/" + part
+				parent, _ = parent.Child(current, true)
+			}
+			if _, got := parent.Child(tt.path, tt.isDir); got != tt.filtered {
+				t.Errorf("Child(%q) = %v, want %v", tt.path, got, tt.filtered)
+			}
+		})
+	}
+}
+
+func pathToParts(path string) []string {
+	if path == "/" {
+		return nil
+	}
+	return strings.Split(path, "/")[1:]
+}
+
+// benchmarkConfig returns a configuration with
+// a realistic amount of filters
+func benchmarkConfig() serverConfig {
+	c := testFilterConfig
+	for i := 0; i < 50; i++ {
+		c.PrefixFilters = append(c.PrefixFilters, fmt.Sprintf("/mnt/backup%d", i))
+		c.SubstringFilters = append(c.SubstringFilters, fmt.Sprintf("build-output-%d", i))
+	}
+	return c
+}
+
+const benchmarkPath = "/home/user/projects/gosearch/internal/config/matcher_test.go"
+
+func BenchmarkNaiveMatch(b *testing.B) {
+	c := benchmarkConfig()
+	regexes := make([]*regexp.Regexp, 0, len(c.RegexFilters))
+	for _, r := range c.RegexFilters {
+		regexes = append(regexes, regexp.MustCompile(r))
+	}
+	c.RegexFilters = nil
+
+	for i := 0; i < b.N; i++ {
+		if !naiveMatch(c, benchmarkPath) {
+			for _, r := range regexes {
+				r.MatchString(benchmarkPath)
+			}
+		}
+	}
+}
+
+func BenchmarkIsEntryFiltered(b *testing.B) {
+	defer state.Store(current())
+	state.Store(newConfigState(benchmarkConfig()))
+
+	for i := 0; i < b.N; i++ {
+		IsEntryFiltered(benchmarkPath, false)
+	}
+}
+
+func BenchmarkFilter_Child(b *testing.B) {
+	defer state.Store(current())
+	state.Store(newConfigState(benchmarkConfig()))
+
+	parent, _ := PathFilter("/home/user/projects/gosearch/internal/config", true)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parent.Child(benchmarkPath, false)
+	}
+}
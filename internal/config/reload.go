@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+)
+
+// ReloadSummary describes how a reloaded configuration
+// differs from the previous one
+type ReloadSummary struct {
+	// RootsChanged is set when roots or their watchers changed
+	RootsChanged bool
+	// Loosened is set when paths that were filtered before
+	// may not be filtered anymore, the file system then has to
+	// be read again to find them
+	Loosened bool
+}
+
+// Reload reads the config file again and replaces the current
+// configuration with it, the current configuration is kept
+// if the file can't be read or contains errors
+func Reload() (ReloadSummary, error) {
+	data, err := ioutil.ReadFile(ConfigPath())
+	if err != nil {
+		return ReloadSummary{}, err
+	}
+
+	problems := Validate(data)
+	for _, p := range problems {
+		slog.Warn("problem in the config file", "line", p.Line,
+			"severity", p.Severity.String(), "problem", p.Message)
+	}
+	if HasErrors(problems) {
+		return ReloadSummary{}, fmt.Errorf("the config file contains %d problems", len(problems))
+	}
+
+	c := defaultConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return ReloadSummary{}, err
+	}
+
+	old := current()
+	s := newConfigState(c)
+	state.Store(s)
+	// the outputs of the logger are only set up on start,
+	// but its level can be changed
+	logLevel.Set(parseLogLevel(c.LogLevel))
+
+	return ReloadSummary{
+		RootsChanged: rootsChanged(old, s),
+		Loosened:     loosened(old, s),
+	}, nil
+}
+
+func rootsChanged(old, s *configState) bool {
+	if len(old.roots) != len(s.roots) {
+		return true
+	}
+	for i := range old.roots {
+		if old.roots[i].path != s.roots[i].path ||
+			old.roots[i].watcher != s.roots[i].watcher {
+			return true
+		}
+	}
+	return old.config.Watcher != s.config.Watcher
+}
+
+// loosened returns whether s may index paths that old filtered,
+// which is the case unless all of old's roots and filters
+// are still part of s
+func loosened(old, s *configState) bool {
+	oldRoots := make(map[string]rootConfig, len(old.roots))
+	for _, r := range old.roots {
+		oldRoots[r.path] = r.config
+	}
+
+	for _, r := range s.roots {
+		oldRoot, ok := oldRoots[r.path]
+		if !ok {
+			return true
+		}
+		if !filtersKept(oldRoot.PrefixFilters, oldRoot.SubstringFilters,
+			oldRoot.RegexFilters, oldRoot.IgnoreHiddenFiles,
+			r.config.PrefixFilters, r.config.SubstringFilters,
+			r.config.RegexFilters, r.config.IgnoreHiddenFiles) ||
+			!ignorePatternsKept(oldRoot.IgnorePatterns, r.config.IgnorePatterns) ||
+			!isSubset(r.config.IncludePatterns, oldRoot.IncludePatterns) {
+			return true
+		}
+	}
+
+	if old.config.UseIgnoreFiles && !s.config.UseIgnoreFiles {
+		return true
+	}
+	if !isSubset(s.config.IncludePatterns, old.config.IncludePatterns) {
+		return true
+	}
+
+	return !filtersKept(old.config.PrefixFilters, old.config.SubstringFilters,
+		old.config.RegexFilters, old.config.IgnoreHiddenFiles,
+		s.config.PrefixFilters, s.config.SubstringFilters,
+		s.config.RegexFilters, s.config.IgnoreHiddenFiles) ||
+		!ignorePatternsKept(old.config.IgnorePatterns, s.config.IgnorePatterns)
+}
+
+// ignorePatternsKept returns whether patterns ignore at least the paths
+// that oldPatterns ignored, new negated patterns may re-include paths
+func ignorePatternsKept(oldPatterns, patterns []string) bool {
+	if !isSubset(oldPatterns, patterns) {
+		return false
+	}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") && !isSubset([]string{pattern}, oldPatterns) {
+			return false
+		}
+	}
+	return true
+}
+
+func filtersKept(oldPrefixes, oldSubstrings, oldRegexes []string, oldHidden bool,
+	prefixes, substrings, regexes []string, hidden bool) bool {
+	if oldHidden && !hidden {
+		return false
+	}
+	return isSubset(oldPrefixes, prefixes) &&
+		isSubset(oldSubstrings, substrings) &&
+		isSubset(oldRegexes, regexes)
+}
+
+func isSubset(subset, set []string) bool {
+	contained := make(map[string]bool, len(set))
+	for _, s := range set {
+		contained[s] = true
+	}
+	for _, s := range subset {
+		if !contained[s] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ozeidan/gosearch/internal/ignore"
+)
+
+// rootConfig configures a directory that is indexed
+// in addition to the global filters, each root can have its own
+// filters and watcher backend
+type rootConfig struct {
+	Path              string   `json:"path"`
+	PrefixFilters     []string `json:"prefix_filters,omitempty"`
+	SubstringFilters  []string `json:"substring_filters,omitempty"`
+	RegexFilters      []string `json:"regex_filters,omitempty"`
+	IgnorePatterns    []string `json:"ignore_patterns,omitempty"`
+	IncludePatterns   []string `json:"include_patterns,omitempty"`
+	IgnoreHiddenFiles bool     `json:"ignore_hidden_files,omitempty"`
+	Watcher           string   `json:"watcher,omitempty"`
+}
+
+// UnmarshalJSON allows roots to be given as plain paths
+// when no per-root settings are needed
+func (r *rootConfig) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*r = rootConfig{Path: path}
+		return nil
+	}
+
+	type plainRootConfig rootConfig
+	return json.Unmarshal(data, (*plainRootConfig)(r))
+}
+
+type root struct {
+	path    string
+	watcher string
+	// matcher holds the global filters together with the root's filters
+	matcher      *matcher
+	ignoreRules  *ignore.Rules
+	includeRules *ignore.Rules
+	config       rootConfig
+}
+
+// passwdPath is a variable so that tests can replace it
+var passwdPath = "/etc/passwd"
+
+// fallbackHome is indexed in home_only mode if the home directories
+// can't be read from passwdPath
+const fallbackHome = "/home"
+
+// the lowest uid of regular users on most distributions
+const minUserUID = 1000
+
+// parseRoots determines the indexed roots from the configuration:
+// the configured roots, the users' home directories if home_only is set,
+// the own home directory in user mode and "/" if none of these apply
+func parseRoots(global serverConfig) []root {
+	configs := append([]rootConfig{}, global.Roots...)
+	if userMode {
+		configs = append(configs, rootConfig{Path: homeDir()})
+	} else if global.HomeOnly {
+		for _, home := range homeDirectories() {
+			configs = append(configs, rootConfig{Path: home})
+		}
+	}
+	if len(configs) == 0 {
+		configs = append(configs, rootConfig{Path: "/"})
+	}
+
+	globalMatcher := newMatcher(global.PrefixFilters, global.SubstringFilters,
+		validRegexes(global.RegexFilters), global.IgnoreHiddenFiles)
+
+	var roots []root
+	seen := make(map[string]bool, len(configs))
+	for _, c := range configs {
+		if !filepath.IsAbs(c.Path) {
+			slog.Warn("ignoring root, roots have to be absolute paths", "path", c.Path)
+			continue
+		}
+		path := filepath.Clean(c.Path)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		roots = append(roots, root{
+			path:    path,
+			watcher: c.Watcher,
+			matcher: rootMatcher(global, c, globalMatcher),
+			// the root's own patterns come last, so that they take precedence
+			ignoreRules: parsePatterns(path,
+				append(append([]string{}, global.IgnorePatterns...), c.IgnorePatterns...)),
+			includeRules: parsePatterns(path,
+				append(append([]string{}, global.IncludePatterns...), c.IncludePatterns...)),
+			config: c,
+		})
+	}
+
+	// deeper roots come first, so that rootOf finds the closest root
+	sort.Slice(roots, func(i, j int) bool {
+		return len(roots[i].path) > len(roots[j].path)
+	})
+	return roots
+}
+
+// rootMatcher compiles the global filters together with the filters
+// of the root c, roots without own filters share globalMatcher
+func rootMatcher(global serverConfig, c rootConfig, globalMatcher *matcher) *matcher {
+	if len(c.PrefixFilters) == 0 && len(c.SubstringFilters) == 0 &&
+		len(c.RegexFilters) == 0 && !c.IgnoreHiddenFiles {
+		return globalMatcher
+	}
+	return newMatcher(
+		append(append([]string{}, global.PrefixFilters...), c.PrefixFilters...),
+		append(append([]string{}, global.SubstringFilters...), c.SubstringFilters...),
+		validRegexes(append(append([]string{}, global.RegexFilters...), c.RegexFilters...)),
+		global.IgnoreHiddenFiles || c.IgnoreHiddenFiles)
+}
+
+// homeDirectories returns the home directories of the regular users,
+// falling back to /home, the parent of the own home directory
+// isn't used because it is "/" for root
+func homeDirectories() []string {
+	file, err := os.Open(passwdPath)
+	if err != nil {
+		slog.Warn("can't read the home directories, indexing "+fallbackHome,
+			"path", passwdPath, "err", err)
+		return []string{fallbackHome}
+	}
+	defer file.Close()
+
+	homes := userHomes(file)
+	if len(homes) == 0 {
+		slog.Warn("no home directories found, indexing "+fallbackHome,
+			"path", passwdPath)
+		return []string{fallbackHome}
+	}
+	return homes
+}
+
+// userHomes returns the existing home directories of the users in the
+// passwd file read from r, system users and users that can't log in,
+// like nobody, are skipped
+func userHomes(r io.Reader) []string {
+	var homes []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil || uid < minUserUID || !isLoginShell(fields[6]) {
+			continue
+		}
+		if info, err := os.Stat(fields[5]); err == nil && info.IsDir() {
+			homes = append(homes, fields[5])
+		}
+	}
+	return homes
+}
+
+// isLoginShell returns whether shell lets the user log in,
+// an empty shell stands for /bin/sh
+func isLoginShell(shell string) bool {
+	name := filepath.Base(shell)
+	return name != "nologin" && name != "false"
+}
+
+// Roots returns the directories that are indexed
+func Roots() []string {
+	roots := current().roots
+	paths := make([]string, 0, len(roots))
+	for _, r := range roots {
+		paths = append(paths, r.path)
+	}
+	return paths
+}
+
+// RemoveNested returns paths without the paths that are
+// inside of another one of the paths
+func RemoveNested(paths []string) []string {
+	outermost := make([]string, 0, len(paths))
+	for _, path := range paths {
+		nested := false
+		for _, other := range paths {
+			if other != path && isParentOrSelf(other, path) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outermost = append(outermost, path)
+		}
+	}
+	return outermost
+}
+
+// IsRoot returns whether path is one of the indexed roots
+func IsRoot(path string) bool {
+	for _, r := range current().roots {
+		if r.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// RootWatcherBackend returns the watcher backend configured for the
+// root at path, falling back to the global watcher setting
+func RootWatcherBackend(path string) string {
+	for _, r := range current().roots {
+		if r.path == path && r.watcher != "" {
+			return r.watcher
+		}
+	}
+	return WatcherBackend()
+}
+
+// rootOf returns the closest root that contains path,
+// or nil if path isn't inside of a root
+func (s *configState) rootOf(path string) *root {
+	for i := range s.roots {
+		if isParentOrSelf(s.roots[i].path, path) {
+			return &s.roots[i]
+		}
+	}
+	return nil
+}
+
+// isRootParent returns whether path is a parent directory of a root
+func (s *configState) isRootParent(path string) bool {
+	for _, r := range s.roots {
+		if isParentOrSelf(path, r.path) {
+			return true
+		}
+	}
+	return false
+}
+
+func isParentOrSelf(parent, path string) bool {
+	return parent == "/" || path == parent ||
+		(len(path) > len(parent) && path[len(parent)] == '/' &&
+			strings.HasPrefix(path, parent))
+}
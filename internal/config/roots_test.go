@@ -0,0 +1,55 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUserHomes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-roots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	home := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	alice, bob, nobody, daemon, service := home("alice"), home("bob"), home("nobody"), home("daemon"), home("service")
+
+	passwd := strings.Join([]string{
+		"alice:x:1000:1000:Alice:" + alice + ":/bin/bash",
+		"bob:x:1001:1001::" + bob + ":",
+		"nobody:x:65534:65534:nobody:" + nobody + ":/usr/sbin/nologin",
+		"daemon:x:1:1:daemon:" + daemon + ":/bin/sh",
+		"service:x:1002:1002::" + service + ":/bin/false",
+		"gone:x:1003:1003::" + filepath.Join(dir, "gone") + ":/bin/bash",
+		"invalid",
+	}, "\n")
+	want := []string{alice, bob}
+	if got := userHomes(strings.NewReader(passwd)); !reflect.DeepEqual(got, want) {
+		t.Errorf("userHomes() = %v, want %v", got, want)
+	}
+}
+
+func TestHomeDirectoriesFallback(t *testing.T) {
+	oldPasswdPath, oldHome := passwdPath, os.Getenv("HOME")
+	defer func() {
+		passwdPath = oldPasswdPath
+		os.Setenv("HOME", oldHome)
+	}()
+	passwdPath = filepath.Join(os.TempDir(), "gosearch-missing-passwd")
+	os.Setenv("HOME", "/root")
+
+	want := []string{fallbackHome}
+	if got := homeDirectories(); !reflect.DeepEqual(got, want) {
+		t.Errorf("homeDirectories() = %v, want %v", got, want)
+	}
+}
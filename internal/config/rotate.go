@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file that is rotated when it grows beyond
+// maxSize bytes, keeping at most maxFiles old files named path.1,
+// path.2 and so on, where path.1 is the newest one
+type rotatingFile struct {
+	mutex    sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// openRotatingFile opens the log file at path for appending,
+// a maxSize of zero disables the rotation
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err == nil {
+		err = f.shift()
+	}
+	// the file is opened again even if it couldn't be rotated,
+	// so that the following writes don't fail as well
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift renames the log file and the rotated files to the next
+// rotated path, removing the oldest one
+func (f *rotatingFile) shift() error {
+	os.Remove(f.rotatedPath(f.maxFiles))
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		os.Rename(f.rotatedPath(i), f.rotatedPath(i+1))
+	}
+	if f.maxFiles > 0 {
+		return os.Rename(f.path, f.rotatedPath(1))
+	}
+	return os.Remove(f.path)
+}
+
+func (f *rotatingFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// Close closes the current log file
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
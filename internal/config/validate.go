@@ -0,0 +1,484 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ozeidan/gosearch/internal/ignore"
+)
+
+// Severity describes how serious a Problem is
+type Severity int
+
+const (
+	// Warning is a problem that doesn't prevent the server from starting
+	Warning Severity = iota
+	// Error is a problem that makes the configuration unusable
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem describes an issue found in the configuration file
+type Problem struct {
+	Line     int
+	Severity Severity
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Severity, p.Message)
+}
+
+// HasErrors returns whether any of the problems is an error
+func HasErrors(problems []Problem) bool {
+	for _, p := range problems {
+		if p.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFile checks the configuration file at path and
+// returns all problems that were found in it
+func ValidateFile(path string) ([]Problem, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(data), nil
+}
+
+var validBackends = []string{"auto", "fanotify", "inotify", "rescan"}
+
+var validLogLevels = []string{"debug", "info", "warn", "error"}
+
+// Validate checks the configuration data and returns all problems
+// that were found in it: syntax errors, unknown keys, values of the wrong
+// type, invalid regular expressions, relative paths and overlapping roots
+func Validate(data []byte) []Problem {
+	v := validator{data: data}
+
+	node, err := v.parse()
+	if err != nil {
+		return []Problem{v.syntaxProblem(err)}
+	}
+
+	if node.kind != objectNode {
+		v.errorf(node, "the configuration has to be a JSON object")
+		return v.problems
+	}
+
+	v.checkObject(node, reflect.TypeOf(serverConfig{}))
+	v.checkFilters(node)
+	v.checkBackend(node.field("watcher"))
+	v.checkOneOf(node.field("log_level"), "log level", validLogLevels)
+	v.checkOneOf(node.field("log_format"), "log format", logFormats)
+
+	if rootsNode := node.field("roots"); rootsNode != nil && rootsNode.kind == arrayNode {
+		v.checkRoots(rootsNode)
+	}
+
+	if address := node.field("metrics_listen"); address != nil &&
+		address.kind == stringNode && address.str != "" {
+		if _, _, err := net.SplitHostPort(address.str); err != nil {
+			v.errorf(address, "invalid metrics_listen address: %v", err)
+		}
+	}
+
+	if interval := node.field("rescan_interval_s"); interval != nil &&
+		interval.kind == numberNode && interval.number <= 0 {
+		v.warnf(interval, "rescan_interval_s has to be positive, using 60 seconds")
+	}
+
+	if parallelism := node.field("walk_parallelism"); parallelism != nil &&
+		parallelism.kind == numberNode && parallelism.number < 0 {
+		v.warnf(parallelism, "walk_parallelism can't be negative, using the number of CPUs")
+	}
+
+	if size := node.field("path_cache_size"); size != nil &&
+		size.kind == numberNode && size.number < 0 {
+		v.warnf(size, "path_cache_size can't be negative, the cache is disabled")
+	}
+
+	if size := node.field("log_max_size_mb"); size != nil &&
+		size.kind == numberNode && size.number <= 0 {
+		v.warnf(size, "log_max_size_mb isn't positive, the log file is never rotated")
+	}
+
+	if files := node.field("log_max_files"); files != nil &&
+		files.kind == numberNode && files.number < 0 {
+		v.errorf(files, "log_max_files can't be negative")
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	return v.problems
+}
+
+type nodeKind int
+
+const (
+	objectNode nodeKind = iota
+	arrayNode
+	stringNode
+	numberNode
+	boolNode
+	nullNode
+)
+
+var kindNames = map[nodeKind]string{
+	objectNode: "an object",
+	arrayNode:  "an array",
+	stringNode: "a string",
+	numberNode: "a number",
+	boolNode:   "a boolean",
+	nullNode:   "null",
+}
+
+type jsonField struct {
+	key   string
+	value *jsonNode
+	// offset of the key in the data
+	offset int64
+}
+
+// jsonNode is a parsed JSON value that remembers its position
+type jsonNode struct {
+	kind     nodeKind
+	offset   int64
+	fields   []jsonField
+	elements []*jsonNode
+	str      string
+	number   float64
+}
+
+func (n *jsonNode) field(key string) *jsonNode {
+	for _, f := range n.fields {
+		if f.key == key {
+			return f.value
+		}
+	}
+	return nil
+}
+
+type validator struct {
+	data     []byte
+	decoder  *json.Decoder
+	problems []Problem
+}
+
+func (v *validator) parse() (*jsonNode, error) {
+	v.decoder = json.NewDecoder(bytes.NewReader(v.data))
+	v.decoder.UseNumber()
+
+	node, err := v.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := v.decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = &json.SyntaxError{Offset: v.decoder.InputOffset()}
+		}
+		return nil, err
+	}
+	return node, nil
+}
+
+func (v *validator) parseValue() (*jsonNode, error) {
+	offset := v.nextOffset()
+	token, err := v.decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &jsonNode{offset: offset}
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			node.kind = objectNode
+			for v.decoder.More() {
+				keyOffset := v.nextOffset()
+				keyToken, err := v.decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := v.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				node.fields = append(node.fields,
+					jsonField{keyToken.(string), value, keyOffset})
+			}
+		} else {
+			node.kind = arrayNode
+			for v.decoder.More() {
+				element, err := v.parseValue()
+				if err != nil {
+					return nil, err
+				}
+				node.elements = append(node.elements, element)
+			}
+		}
+		// closing delimiter
+		if _, err := v.decoder.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		node.kind = stringNode
+		node.str = t
+	case json.Number:
+		node.kind = numberNode
+		node.number, _ = t.Float64()
+	case bool:
+		node.kind = boolNode
+	case nil:
+		node.kind = nullNode
+	}
+	return node, nil
+}
+
+// nextOffset returns the offset of the next token in the data
+func (v *validator) nextOffset() int64 {
+	offset := v.decoder.InputOffset()
+	for offset < int64(len(v.data)) &&
+		strings.IndexByte(" \t\r\n,:", v.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+func (v *validator) line(offset int64) int {
+	if offset > int64(len(v.data)) {
+		offset = int64(len(v.data))
+	}
+	return bytes.Count(v.data[:offset], []byte("\n")) + 1
+}
+
+func (v *validator) syntaxProblem(err error) Problem {
+	offset := v.decoder.InputOffset()
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		offset = syntaxErr.Offset
+	}
+	message := "invalid JSON: " + err.Error()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		message = "invalid JSON: unexpected end of file"
+	}
+	return Problem{v.line(offset), Error, message}
+}
+
+func (v *validator) errorf(node *jsonNode, format string, args ...interface{}) {
+	v.problems = append(v.problems,
+		Problem{v.line(node.offset), Error, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(node *jsonNode, format string, args ...interface{}) {
+	v.problems = append(v.problems,
+		Problem{v.line(node.offset), Warning, fmt.Sprintf(format, args...)})
+}
+
+// checkObject reports unknown keys and values that don't
+// fit the fields of the struct type t
+func (v *validator) checkObject(node *jsonNode, t reflect.Type) {
+	fieldTypes := jsonFieldTypes(t)
+	seen := make(map[string]bool, len(node.fields))
+
+	for _, f := range node.fields {
+		keyNode := &jsonNode{offset: f.offset}
+		fieldType, ok := fieldTypes[f.key]
+		if !ok {
+			v.errorf(keyNode, "unknown key %q", f.key)
+			continue
+		}
+		if seen[f.key] {
+			v.warnf(keyNode, "duplicate key %q, only the last value is used", f.key)
+		}
+		seen[f.key] = true
+		v.checkType(f.key, f.value, fieldType)
+	}
+}
+
+func (v *validator) checkType(name string, node *jsonNode, t reflect.Type) {
+	// roots may be given as plain paths or as objects
+	if t == reflect.TypeOf(rootConfig{}) {
+		switch node.kind {
+		case stringNode:
+			return
+		case objectNode:
+			v.checkObject(node, t)
+			return
+		}
+		v.errorf(node, "%s has to be a path or an object, not %s",
+			name, kindNames[node.kind])
+		return
+	}
+
+	var want nodeKind
+	switch t.Kind() {
+	case reflect.String:
+		want = stringNode
+	case reflect.Bool:
+		want = boolNode
+	case reflect.Int:
+		want = numberNode
+	case reflect.Slice:
+		want = arrayNode
+	}
+
+	if node.kind != want {
+		v.errorf(node, "%s has to be %s, not %s",
+			name, kindNames[want], kindNames[node.kind])
+		return
+	}
+
+	if node.kind == numberNode && node.number != float64(int(node.number)) {
+		v.errorf(node, "%s has to be a whole number", name)
+	}
+
+	if node.kind == arrayNode {
+		for _, element := range node.elements {
+			v.checkType("an entry of "+name, element, t.Elem())
+		}
+	}
+}
+
+// jsonFieldTypes maps the JSON keys of the struct type t to their types
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fieldTypes := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		fieldTypes[name] = field.Type
+	}
+	return fieldTypes
+}
+
+// checkFilters checks the filters of node, which is either
+// the whole configuration or a root
+func (v *validator) checkFilters(node *jsonNode) {
+	if prefixes := node.field("prefix_filters"); prefixes != nil {
+		for _, prefix := range prefixes.elements {
+			if prefix.kind == stringNode && !strings.HasPrefix(prefix.str, "/") {
+				v.errorf(prefix, "prefix filter %q has to be an absolute path", prefix.str)
+			}
+		}
+	}
+
+	if regexes := node.field("regex_filters"); regexes != nil {
+		for _, regex := range regexes.elements {
+			if regex.kind != stringNode {
+				continue
+			}
+			if _, err := regexp.Compile(regex.str); err != nil {
+				v.errorf(regex, "invalid regex filter: %v", err)
+			}
+		}
+	}
+
+	for _, key := range []string{"ignore_patterns", "include_patterns"} {
+		patterns := node.field(key)
+		if patterns == nil {
+			continue
+		}
+		for _, pattern := range patterns.elements {
+			if pattern.kind != stringNode {
+				continue
+			}
+			if err := ignore.Check(pattern.str); err != nil {
+				v.errorf(pattern, "invalid pattern in %s: %v", key, err)
+			}
+		}
+	}
+}
+
+func (v *validator) checkBackend(node *jsonNode) {
+	v.checkOneOf(node, "watcher", validBackends)
+}
+
+// checkOneOf reports string values of node that aren't in valid
+func (v *validator) checkOneOf(node *jsonNode, name string, valid []string) {
+	if node == nil || node.kind != stringNode {
+		return
+	}
+	for _, value := range valid {
+		if node.str == value {
+			return
+		}
+	}
+	v.errorf(node, "unknown %s %q, has to be one of %s",
+		name, node.str, strings.Join(valid, ", "))
+}
+
+type rootNode struct {
+	path string
+	node *jsonNode
+}
+
+func containsRoot(roots []rootNode, path string) bool {
+	for _, r := range roots {
+		if r.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) checkRoots(node *jsonNode) {
+	var roots []rootNode
+
+	for _, element := range node.elements {
+		pathNode := element
+		if element.kind == objectNode {
+			v.checkFilters(element)
+			v.checkBackend(element.field("watcher"))
+			pathNode = element.field("path")
+			if pathNode == nil {
+				v.errorf(element, "root is missing a path")
+				continue
+			}
+		}
+		if pathNode.kind != stringNode {
+			continue
+		}
+
+		if !filepath.IsAbs(pathNode.str) {
+			v.errorf(pathNode, "root %q has to be an absolute path", pathNode.str)
+			continue
+		}
+		roots = append(roots, rootNode{filepath.Clean(pathNode.str), pathNode})
+	}
+
+	for i, r := range roots {
+		if containsRoot(roots[:i], r.path) {
+			v.errorf(r.node, "root %q is listed more than once", r.path)
+			continue
+		}
+		for _, other := range roots[:i] {
+			switch {
+			case isParentOrSelf(other.path, r.path):
+				v.warnf(r.node, "root %q overlaps with root %q, only the global "+
+					"filters and its own apply to it", r.path, other.path)
+			case isParentOrSelf(r.path, other.path):
+				v.warnf(r.node, "root %q overlaps with root %q, only the global "+
+					"filters and those of %q apply inside of it", r.path, other.path, other.path)
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		lines []int
+		want  []Severity
+	}{
+		{
+			"valid",
+			`{"prefix_filters": ["/proc"], "roots": ["/home", {"path": "/srv"}]}`,
+			[]int{},
+			[]Severity{},
+		},
+		{
+			"syntax_error",
+			"{\n\"print_logs\": true,\n}",
+			[]int{2},
+			[]Severity{Error},
+		},
+		{
+			"unknown_key",
+			"{\n\"print_logs\": true,\n\"ignore_hiden_files\": true\n}",
+			[]int{3},
+			[]Severity{Error},
+		},
+		{
+			"wrong_type",
+			"{\n\"coalesce_window_ms\": \"100\"\n}",
+			[]int{2},
+			[]Severity{Error},
+		},
+		{
+			"invalid_regex",
+			"{\n\"regex_filters\": [\n\"ok\",\n\"(unclosed\"\n]\n}",
+			[]int{4},
+			[]Severity{Error},
+		},
+		{
+			"invalid_ignore_pattern",
+			"{\n\"ignore_patterns\": [\"*.o\", \"[abc\"]\n}",
+			[]int{2},
+			[]Severity{Error},
+		},
+		{
+			"unknown_log_level",
+			"{\n\"log_level\": \"verbose\",\n\"log_format\": \"json\"\n}",
+			[]int{2},
+			[]Severity{Error},
+		},
+		{
+			"relative_prefix",
+			"{\n\"prefix_filters\": [\"tmp\"]\n}",
+			[]int{2},
+			[]Severity{Error},
+		},
+		{
+			"overlapping_roots",
+			"{\"roots\": [\n\"/home\",\n{\"path\": \"/home/user\"},\n\"/home\"\n]}",
+			[]int{3, 4},
+			[]Severity{Warning, Error},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := Validate([]byte(tt.data))
+			lines := []int{}
+			severities := []Severity{}
+			for _, p := range problems {
+				lines = append(lines, p.Line)
+				severities = append(severities, p.Severity)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) ||
+				!reflect.DeepEqual(severities, tt.want) {
+				t.Errorf("Validate() = %v, want lines %v with severities %v",
+					problems, tt.lines, tt.want)
+			}
+		})
+	}
+}
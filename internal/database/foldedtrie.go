@@ -0,0 +1,85 @@
+package database
+
+import (
+	trie "gopkg.in/ozeidan/fuzzy-patricia.v3/patricia"
+)
+
+// foldedTrie maps the case folded names of the index to the names,
+// so case insensitive searches can use a trie instead of comparing
+// every name, it is nil unless case_insensitive_index is set,
+// as it takes about as much memory as indexTrie
+var foldedTrie *trie.Trie
+
+// caseFolder builds the keys of foldedTrie
+var caseFolder = newFolder(true, false)
+
+// buildFoldedTrie creates foldedTrie from the names in the index
+func buildFoldedTrie() {
+	start := logStart("build the folded index")
+	foldedTrie = trie.NewTrie()
+	indexTrie.Visit(func(prefix trie.Prefix, item trie.Item) error {
+		foldedAdd(string(prefix))
+		return nil
+	})
+	logStop("build the folded index", start)
+}
+
+// foldedAdd adds a name that is new to the index to foldedTrie
+func foldedAdd(name string) {
+	key := trie.Prefix(caseFolder.fold(name))
+	if item := foldedTrie.Get(key); item != nil {
+		foldedTrie.Set(key, append(item.([]string), name))
+		return
+	}
+	foldedTrie.Insert(key, []string{name})
+}
+
+// foldedRemove removes a name that isn't in the index anymore
+// from foldedTrie
+func foldedRemove(name string) {
+	key := trie.Prefix(caseFolder.fold(name))
+	item := foldedTrie.Get(key)
+	if item == nil {
+		return
+	}
+
+	names := item.([]string)
+	for i := range names {
+		if names[i] != name {
+			continue
+		}
+		if len(names) == 1 {
+			foldedTrie.Delete(key)
+			return
+		}
+		foldedTrie.Set(key, append(names[:i], names[i+1:]...))
+		return
+	}
+}
+
+// foldedVisitor returns a visitor of foldedTrie that calls
+// visitor for the names of the index with the visited key
+func foldedVisitor(visitor trie.VisitorFunc) trie.VisitorFunc {
+	return func(key trie.Prefix, item trie.Item) error {
+		for _, name := range item.([]string) {
+			prefix := trie.Prefix(name)
+			if err := visitor(prefix, indexTrie.Get(prefix)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// foldedFuzzyVisitor is foldedVisitor for fuzzy searches
+func foldedFuzzyVisitor(visitor trie.FuzzyVisitorFunc) trie.FuzzyVisitorFunc {
+	return func(key trie.Prefix, item trie.Item, skipped int) error {
+		for _, name := range item.([]string) {
+			prefix := trie.Prefix(name)
+			if err := visitor(prefix, indexTrie.Get(prefix), skipped); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ozeidan/gosearch/internal/request"
+)
+
+func TestFoldedTrie(t *testing.T) {
+	defer resetWalkedIndex()()
+	add := func(name string) {
+		indexTrieAdd(name, indexedFile{*fileTree.Add("/root/" + name), false})
+	}
+	for _, name := range []string{"README.md", "readme.txt", "Straße", "Makefile"} {
+		add(name)
+	}
+	caseInsensitive := func(action int, q string) []string {
+		return sortedQuery(q, request.Settings{Action: action, CaseInsensitive: true})
+	}
+
+	// without the folded trie every name is compared
+	want := []string{"/root/README.md", "/root/readme.txt"}
+	if got := caseInsensitive(request.PrefixSearch, "Read"); !reflect.DeepEqual(got, want) {
+		t.Errorf("prefix search without the folded trie returned %q, want %q", got, want)
+	}
+	buildFoldedTrie()
+	defer func() { foldedTrie = nil }()
+	if got := caseInsensitive(request.PrefixSearch, "Read"); !reflect.DeepEqual(got, want) {
+		t.Errorf("prefix search returned %q, want %q", got, want)
+	}
+
+	// the folded trie is updated with the index
+	add("ReadMe.rst")
+	removeFromIndex("/root", "README.md")
+	removeFromIndex("/root", "Makefile")
+	tests := []struct {
+		action int
+		query  string
+		want   []string
+	}{
+		{request.PrefixSearch, "README", []string{"/root/ReadMe.rst", "/root/readme.txt"}},
+		{request.SubStringSearch, "ME.", []string{"/root/ReadMe.rst", "/root/readme.txt"}},
+		{request.SubStringSearch, "STRASS", []string{"/root/Straße"}},
+		{request.FuzzySearch, "mkf", nil},
+		{request.FuzzySearch, "RMR", []string{"/root/ReadMe.rst"}},
+	}
+	for _, tt := range tests {
+		t.Run(request.ActionName(tt.action)+"_"+tt.query, func(t *testing.T) {
+			if got := caseInsensitive(tt.action, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("query returned %q, want %q", got, tt.want)
+			}
+			folded := foldedTrie
+			foldedTrie = nil
+			defer func() { foldedTrie = folded }()
+			if got := caseInsensitive(tt.action, tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("query without the folded trie returned %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkCaseInsensitivePrefixSearch compares smart case searches
+// for lower-case queries, which clients send by default, with and
+// without the folded trie
+func BenchmarkCaseInsensitivePrefixSearch(b *testing.B) {
+	defer resetWalkedIndex()()
+	indexProjects("/root", 50000)
+
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("case_insensitive_index_%v", enabled), func(b *testing.B) {
+			foldedTrie = nil
+			if enabled {
+				buildFoldedTrie()
+			}
+			defer func() { foldedTrie = nil }()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				query("project4242", request.Settings{Action: request.PrefixSearch, SmartCase: true})
+			}
+		})
+	}
+}
+
+// BenchmarkBuildFoldedTrie measures the memory that the folded trie
+// takes, which case_insensitive_index trades for faster searches
+func BenchmarkBuildFoldedTrie(b *testing.B) {
+	resetIndex()
+	indexProjects("/root", 50000)
+	b.ReportAllocs()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildFoldedTrie()
+	}
+	foldedTrie = nil
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/ignore"
+	"github.com/ozeidan/gosearch/pkg/tree"
+)
+
+// ignoreFiles holds the rules of the ignore files of a directory
+// and the modification times of the files they were read from
+type ignoreFiles struct {
+	rules    *ignore.Rules
+	modTimes []time.Time
+}
+
+// ignoreRules holds the rules of the .gitignore/.ignore files,
+// keyed by the tree node of the directory containing them
+// only directories that contain ignore files are stored
+var ignoreRules = make(map[tree.Node]ignoreFiles)
+
+// entryFilter matches the entries of a directory against the
+// configuration and the ignore files, the directory is matched
+// once for all of its entries, like readWalkEntry does
+type entryFilter struct {
+	filter  config.Filter
+	ignores *ignoreChain
+	// dirIgnored is set if the directory or one of its parents is
+	// ignored, like in git the entries below are ignored as well
+	dirIgnored bool
+}
+
+func newEntryFilter(dir string) entryFilter {
+	filter, _ := config.PathFilter(dir, true)
+	ignores, dirIgnored := loadedIgnores(dir)
+	return entryFilter{filter, ignores, dirIgnored}
+}
+
+// isFiltered returns whether the entry at path, which has to be
+// inside of the directory of f, is excluded
+func (f entryFilter) isFiltered(path string, isDir bool) bool {
+	filter, filtered := f.filter.Child(path, isDir)
+	return filtered ||
+		(!filter.Included() && (f.dirIgnored || f.ignores.isIgnored(path, isDir)))
+}
+
+// isEntryFiltered returns whether the entry at path is excluded
+// by the configuration or by the ignore files of its parent directories
+func isEntryFiltered(path string, isDir bool) bool {
+	return newEntryFilter(filepath.Dir(path)).isFiltered(path, isDir)
+}
+
+// loadedIgnores links the loaded rules of the ignore files of the
+// directory at path and its parent directories, it also returns
+// whether path or one of its parents is ignored by these rules
+func loadedIgnores(path string) (*ignoreChain, bool) {
+	if len(ignoreRules) == 0 {
+		return nil, false
+	}
+
+	var chain *ignoreChain
+	link := func(node *tree.Node) {
+		if loaded, ok := ignoreRules[*node]; ok {
+			chain = &ignoreChain{loaded.rules, chain}
+		}
+	}
+
+	ignored := false
+	node, dir := fileTree, "/"
+	link(node)
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			// path is the root directory
+			break
+		}
+		child, err := node.Find("/" + name)
+		if err != nil {
+			break
+		}
+		node, dir = child, filepath.Join(dir, name)
+		ignored = ignored || chain.isIgnored(dir, true)
+		link(node)
+	}
+	return chain, ignored
+}
+
+// loadIgnoreFiles reads the ignore files of the directory at path,
+// replacing the rules that were read before, the files are only
+// parsed if they were modified since then
+// it returns whether the rules changed
+func loadIgnoreFiles(path string) bool {
+	if !config.UseIgnoreFiles() {
+		return false
+	}
+
+	node, err := fileTree.Find(path)
+	if err != nil {
+		return false
+	}
+	modTimes := ignoreFileTimes(path)
+	loaded := ignoreRules[*node]
+	if equalTimes(modTimes, loaded.modTimes) {
+		return false
+	}
+
+	rules, err := ignore.ParseFiles(path)
+	if err != nil {
+		slog.Warn("couldn't parse the ignore files", "path", path, "err", err)
+	}
+	changed := !rules.Equal(loaded.rules)
+	if rules == nil {
+		delete(ignoreRules, *node)
+	} else {
+		ignoreRules[*node] = ignoreFiles{rules, modTimes}
+	}
+	return changed
+}
+
+// ignoreFileTimes returns the modification times of the ignore files
+// in dir in the order of ignore.FileNames, the time of missing files
+// is zero, it returns nil if the directory doesn't contain any
+func ignoreFileTimes(dir string) []time.Time {
+	var modTimes []time.Time
+	for i, name := range ignore.FileNames {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		if modTimes == nil {
+			modTimes = make([]time.Time, len(ignore.FileNames))
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetIgnoreFiles removes the rules of the directory of node,
+// deleteFromIndex calls it for every directory of a removed subtree
+func forgetIgnoreFiles(node tree.Node) {
+	delete(ignoreRules, node)
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/ignore"
+)
+
+func TestIgnoreFileTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if modTimes := ignoreFileTimes(dir); modTimes != nil {
+		t.Errorf("ignoreFileTimes() = %v for a directory without ignore files", modTimes)
+	}
+
+	path := filepath.Join(dir, ".ignore")
+	if err := ioutil.WriteFile(path, []byte("*.o\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before := ignoreFileTimes(dir)
+	if len(before) != 2 || !before[0].IsZero() || before[1].IsZero() {
+		t.Fatalf("ignoreFileTimes() = %v, want the time of .ignore only", before)
+	}
+	if !equalTimes(ignoreFileTimes(dir), before) {
+		t.Errorf("the times of the unmodified files differ")
+	}
+
+	modified := before[1].Add(time.Second)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	if equalTimes(ignoreFileTimes(dir), before) {
+		t.Errorf("the times of the modified files are equal")
+	}
+}
+
+func TestEntryFilter(t *testing.T) {
+	root := tempTree(t, 2, 2)
+	defer os.RemoveAll(root)
+	defer func() { indexingDone = false }()
+	parallelWalk(root, 4)
+
+	rules, err := ignore.Parse(root, []string{"dir0/", "file1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := fileTree.Find(root)
+	ignoreRules[*node] = ignoreFiles{rules: rules}
+
+	tests := []struct {
+		dir      string
+		name     string
+		isDir    bool
+		filtered bool
+	}{
+		{"", "dir0", true, true},
+		{"", "dir1", true, false},
+		{"", "file0", false, false},
+		{"dir1", "file1", false, true},
+		{"dir1/dir0", "file0", false, true},
+		{"dir1/dir1", "file0", false, false},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(root, tt.dir)
+		if got := newEntryFilter(dir).isFiltered(filepath.Join(dir, tt.name), tt.isDir); got != tt.filtered {
+			t.Errorf("%s filtered = %v, want %v", filepath.Join(tt.dir, tt.name), got, tt.filtered)
+		}
+	}
+
+	// the rules are forgotten with the directory
+	removeFromIndex(filepath.Dir(root), filepath.Base(root))
+	if len(ignoreRules) != 0 {
+		t.Errorf("the rules of %d directories are still loaded", len(ignoreRules))
+	}
+}
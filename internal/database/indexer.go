@@ -1,108 +1,270 @@
 package database
 
 import (
-	"errors"
-	"log"
+	"context"
+	"log/slog"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/karrick/godirwalk"
 	"github.com/ozeidan/gosearch/internal/config"
-	"github.com/ozeidan/gosearch/internal/fanotify"
 	"github.com/ozeidan/gosearch/internal/request"
+	"github.com/ozeidan/gosearch/internal/watcher"
 	"github.com/ozeidan/gosearch/pkg/tree"
 	trie "gopkg.in/ozeidan/fuzzy-patricia.v3/patricia"
 )
 
 // Start starts the indexing and listens for file changes and requests
-// changeSender is used to get file change messages from the caller
+// until Stop is called
+// w is the watcher that reports the file changes
 // requestSender is used to get request messages from the caller
-func Start(changeSender <-chan fanotify.FileChange,
-	requestSender <-chan request.Request) {
-	initialIndex()
+func Start(w watcher.Watcher, requestSender <-chan request.Request) {
+	startTime = time.Now()
+	watcherName = w.Name()
+	changeSender := make(chan watcher.FileChange, 100)
+	go w.Listen(changeSender)
+
+	paths = newPathCache(config.PathCacheSize())
+	startInitialIndex()
 
 	for {
+		// the directories of a walk are read by the workers
+		// of the walker and added in between handling changes and requests,
+		// the reconciliation after a reload is done in batches
+		// and waits for the walk to finish
+		var walkJobs chan<- walkEntry
+		var nextWalkEntry walkEntry
+		var walkResults <-chan walkResult
+		var reconcileReady <-chan struct{}
+		if activeWalk != nil {
+			walkJobs, nextWalkEntry = activeWalk.next()
+			walkResults = activeWalk.pendingResults()
+		} else if lastReload != nil && lastReload.InProgress {
+			reconcileReady = alwaysReady
+		}
+
 		select {
 		case change := <-changeSender:
-			refreshDirectory(change.FolderPath)
+			refreshChange(change)
 		case req := <-requestSender:
 			queryIndex(req)
+		case <-pingSender:
+		case resultReceiver := <-reloadSender:
+			resultReceiver <- reload()
+		case newWatcher := <-watcherSender:
+			w.Close()
+			w = newWatcher
+			watcherName = w.Name()
+			go w.Listen(changeSender)
+		case walkJobs <- nextWalkEntry:
+			activeWalk.sent()
+		case result := <-walkResults:
+			addWalkResult(result)
+		case <-reconcileReady:
+			reconcileBatch()
+		case stopped := <-stopSender:
+			if activeWalk != nil {
+				activeWalk.stop()
+			}
+			close(done)
+			if err := w.Close(); err != nil {
+				slog.Warn("failed to close the watcher", "err", err)
+			}
+			close(stopped)
+			return
 		}
 	}
 }
 
-var errFilter = errors.New("directory filtered")
+var stopSender = make(chan chan struct{})
+var pingSender = make(chan struct{})
+
+// Ping returns once the database handled a ping in between handling
+// file changes and requests, it gives up waiting when ctx is done
+func Ping(ctx context.Context) error {
+	select {
+	case pingSender <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ready is closed when the initial walk is finished
+var ready = make(chan struct{})
+
+// Ready returns a channel that is closed when the initial index
+// is built and queries are answered
+func Ready() <-chan struct{} {
+	return ready
+}
+
+// done is closed when the database stops handling file changes
+var done = make(chan struct{})
+
+// Done returns a channel that is closed when the database stops
+// reading the changes reported by its watcher
+func Done() <-chan struct{} {
+	return done
+}
+
+// IndexSize returns the number of indexed files and directories,
+// it can be called while the initial index is built
+func IndexSize() (files, directories int64) {
+	return int64(indexedFiles.Value()), int64(indexedDirectories.Value())
+}
+
+// Stop stops handling file changes and requests and closes the watcher,
+// it gives up waiting when ctx is done
+func Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	select {
+	case stopSender <- stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	<-stopped
+	return nil
+}
 
 var indexTrie *trie.Trie
 var fileTree *tree.Node
 
 type indexedFile struct {
-	pathNode *tree.Node
+	// pathNode is stored by value, so the index doesn't keep a
+	// separately allocated handle for every entry
+	pathNode tree.Node
+	isDir    bool
 }
 
-func initialIndex() {
-	indexTrie = trie.NewTrie()
-	fileTree = tree.New()
+// refreshChange refreshes the changed directory and the descendants
+// that were merged into the change, skipping descendants which were
+// already walked or removed while refreshing one of their ancestors
+func refreshChange(change watcher.FileChange) {
+	changesProcessed.Inc()
+	lastChangeTime = time.Now()
+	lastChange.Set(float64(lastChangeTime.Unix()))
+
+	covered := refreshDirectory(change.FolderPath)
 
-	log.Println("starting to create initial index")
+	for _, dir := range change.Descendants {
+		if isCovered(dir, covered) {
+			continue
+		}
+		covered = append(covered, refreshDirectory(dir)...)
+	}
+}
 
-	start := time.Now()
-	dirname := "/"
-	files, directories := addToIndexRecursively(dirname)
-	end := time.Now()
+func isCovered(path string, covered []string) bool {
+	for _, coveredPath := range covered {
+		if path == coveredPath ||
+			strings.HasPrefix(path, coveredPath+"/") {
+			return true
+		}
+	}
+	return false
+}
 
-	log.Println("finished creating initial index")
-	log.Printf("indexed %d files and %d directories in %f seconds",
-		files, directories, end.Sub(start).Seconds())
-	PrintMemUsage()
+// refreshDirectory compares the contents of the directory at path
+// with the index and updates the index accordingly
+// it returns the paths of the entries that were added or removed
+func refreshDirectory(path string) []string {
+	if !isWalked(path) {
+		return nil
+	}
+	// directories that aren't in the tree are filtered, or their parents
+	// weren't read yet, their entries mustn't be indexed
+	if _, err := fileTree.Find(path); err != nil {
+		return nil
+	}
+	slog.Debug("refreshing directory", "path", path)
+	defer observeSince(refreshDuration, time.Now())
+	if loadIgnoreFiles(path) {
+		// the changed rules may apply anywhere below the directory,
+		// so its entries are indexed again
+		children, _ := fileTree.GetChildren(path)
+		for _, name := range children {
+			removeFromIndex(path, name)
+		}
+	}
+	newNames, nameDirents := readDirectory(path)
+	return updateDirectory(path, newNames, nameDirents)
 }
 
-func refreshDirectory(path string) {
-	log.Println("refreshing directory", path)
+// readDirectory reads the unfiltered entries of the directory at path
+func readDirectory(path string) ([]string, map[string]godirwalk.Dirent) {
 	newDirents, err := godirwalk.ReadDirents(path, nil)
 	if err != nil {
-		log.Println("warning: couldn't read directory", path, err)
+		slog.Warn("couldn't read directory", "path", path, "err", err)
 	}
 
 	newNames := make([]string, 0, len(newDirents))
 	nameDirents := make(map[string]godirwalk.Dirent, len(newNames))
+	entries := newEntryFilter(path)
 	for _, dirent := range newDirents {
 		name := dirent.Name()
-		if config.IsPathFiltered(filepath.Join(path, name)) {
-			// log.Println("ignoring filtered file", name)
+		if entries.isFiltered(filepath.Join(path, name), dirent.IsDir()) {
 			continue
 		}
 		newNames = append(newNames, name)
 		nameDirents[dirent.Name()] = *dirent
 	}
+	setFiltered(path, len(newNames) < len(newDirents))
+
+	return newNames, nameDirents
+}
 
+// updateDirectory updates the index of the directory at path
+// to contain the entries newNames
+// it returns the paths of the entries that were added or removed
+func updateDirectory(path string, newNames []string,
+	nameDirents map[string]godirwalk.Dirent) []string {
 	oldNames, err := fileTree.GetChildren(path)
 	if err != nil {
-		log.Println("couldn't get children of path", path, err)
+		slog.Error("couldn't get children of path", "path", path, "err", err)
 	}
 
 	createdNames, deletedNames := sliceDifference(newNames, oldNames)
 	if len(createdNames) > 0 {
-		log.Printf("indexing new files %v\n", createdNames)
+		slog.Debug("indexing new files", "path", path, "names", createdNames)
 	}
 	if len(deletedNames) > 0 {
-		log.Printf("removing deleted files %v from index", deletedNames)
+		slog.Debug("removing deleted files from index", "path", path, "names", deletedNames)
 	}
 
+	changedPaths := make([]string, 0, len(createdNames)+len(deletedNames))
+
+	entries := newEntryFilter(path)
 	for _, name := range createdNames {
 		dirent := nameDirents[name]
 		pathName := filepath.Join(path, name)
-		if config.IsPathFiltered(pathName) {
+		if entries.isFiltered(pathName, dirent.IsDir()) {
 			continue
 		}
 		addToIndex(path, name, dirent)
+		changedPaths = append(changedPaths, pathName)
 	}
 
 	for _, name := range deletedNames {
 		pathName := filepath.Join(path, name)
-		deleteFromIndex(path, name)
-		fileTree.DeleteAt(pathName)
+		removeFromIndex(path, name)
+		changedPaths = append(changedPaths, pathName)
+	}
+
+	return changedPaths
+}
+
+// removeFromIndex removes the file or directory name inside of
+// the directory at path and everything below it from the index
+func removeFromIndex(path, name string) {
+	pathName := filepath.Join(path, name)
+	isDir := deleteFromIndex(path, name)
+	fileTree.DeleteAt(pathName)
+	// only directories are read by the walk
+	if isDir {
+		forgetPending(pathName)
 	}
 }
 
@@ -142,92 +304,124 @@ func addToIndex(path, name string, dirent godirwalk.Dirent) {
 	pathName := filepath.Join(path, name)
 
 	if dirent.IsDir() {
-		addToIndexRecursively(pathName)
+		walkDirectory(pathName)
 	} else {
 		newNode := fileTree.Add(pathName)
-		indexTrieAdd(name, indexedFile{newNode})
+		indexTrieAdd(name, indexedFile{*newNode, false})
 	}
 }
 
-func deleteFromIndex(path, name string) {
+// deleteFromIndex removes the entry name of the directory at path and
+// the entries below it from the trie and forgets the state of the
+// directories among them, it returns whether it was a directory
+func deleteFromIndex(path, name string) bool {
 	pathName := filepath.Join(path, name)
 
-	indexTrieDelete(name, path)
-	children, err := fileTree.GetChildren(pathName)
+	isDir := indexTrieDelete(name, path)
+	node, err := fileTree.Find(pathName)
 	if err != nil {
 		// fmt.Println("warning:", err)
-		return
+		return isDir
 	}
+	children, _ := node.GetChildren("/")
 
 	for _, child := range children {
 		deleteFromIndex(pathName, child)
 	}
+	// the parents of nested roots are only part of the tree
+	isDir = isDir || len(children) > 0
+	if isDir {
+		forgetIgnoreFiles(*node)
+		forgetFiltered(*node)
+	}
+	return isDir
 }
 
-func addToIndexRecursively(path string) (uint64, uint64) {
-	var directoryCount uint64
-	var fileCount uint64
-	godirwalk.Walk(path, &godirwalk.Options{
-		Callback: func(osPathname string, de *godirwalk.Dirent) error {
-			if config.IsPathFiltered(osPathname) {
-				return errFilter
-			}
-
-			if de.IsDir() {
-				directoryCount++
-			} else {
-				fileCount++
-			}
-
-			newNode := fileTree.Add(string(osPathname))
-			newFile := indexedFile{newNode}
-			indexTrieAdd(string(de.Name()), newFile)
-
-			return nil
-		},
-		Unsorted: true,
-		ErrorCallback: func(_ string, err error) godirwalk.ErrorAction {
-			if err == errFilter {
-				return godirwalk.SkipNode
-			}
-			// fmt.Println(err)
-			return godirwalk.SkipNode
-		},
-	})
-
-	return fileCount, directoryCount
-}
-
+// indexTrieAdd adds the file to the files with the same normalized name,
+// its position in their list is stored in its tree node,
+// so it can be removed without searching the list
 func indexTrieAdd(name string, index indexedFile) {
+	countEntry(index.isDir, 1)
+	name = normalizeName(name)
 	prefix := trie.Prefix(name)
 	if item := indexTrie.Get(prefix); item != nil {
 		fileList := item.([]indexedFile)
+		index.pathNode.SetValue(uint32(len(fileList)))
 		fileList = append(fileList, index)
 		indexTrie.Set(prefix, fileList)
 	} else {
+		index.pathNode.SetValue(0)
 		indexTrie.Insert(prefix, []indexedFile{index})
+		indexedNames.Add(1)
+		if !isASCII(name) {
+			nonASCIINames[name] = true
+		}
+		if trigrams != nil {
+			trigrams.add(name)
+		}
+		if foldedTrie != nil {
+			foldedAdd(name)
+		}
 	}
 }
 
-func indexTrieDelete(name, path string) {
-	prefix := trie.Prefix(name)
-	filePath := filepath.Join(path, name)
-	if item := indexTrie.Get(prefix); item != nil {
-		fileList := item.([]indexedFile)
-		for i := 0; i < len(fileList); i++ {
-			index := fileList[i]
-			existingPath := index.pathNode.GetPath()
+// indexedEntry returns the files with the normalized name of the
+// entry at path and the position of the entry in their list
+func indexedEntry(path string) (trie.Prefix, []indexedFile, uint32, bool) {
+	node, err := fileTree.Find(path)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	prefix := trie.Prefix(normalizeName(filepath.Base(path)))
+	item := indexTrie.Get(prefix)
+	if item == nil {
+		return nil, nil, 0, false
+	}
 
-			if existingPath != filePath {
-				continue
-			}
+	fileList := item.([]indexedFile)
+	i := node.Value()
+	if int(i) >= len(fileList) || fileList[i].pathNode != *node {
+		return nil, nil, 0, false
+	}
+	return prefix, fileList, i, true
+}
+
+// isIndexedDir returns whether the indexed entry at path is a directory,
+// without reading the file system
+func isIndexedDir(path string) bool {
+	_, fileList, i, ok := indexedEntry(path)
+	return ok && fileList[i].isDir
+}
 
-			fileList[i] = fileList[len(fileList)-1]
-			fileList = fileList[:len(fileList)-1]
-			break
+// indexTrieDelete removes the entry name of the directory at path
+// from the trie, it returns whether the entry was a directory
+func indexTrieDelete(name, path string) bool {
+	prefix, fileList, i, ok := indexedEntry(filepath.Join(path, name))
+	if !ok {
+		return false
+	}
+	isDir := fileList[i].isDir
+	countEntry(isDir, -1)
+	last := len(fileList) - 1
+	fileList[i] = fileList[last]
+	fileList[i].pathNode.SetValue(i)
+	fileList = fileList[:last]
+
+	if len(fileList) == 0 {
+		indexTrie.Delete(prefix)
+		indexedNames.Add(-1)
+		normalized := string(prefix)
+		delete(nonASCIINames, normalized)
+		if trigrams != nil {
+			trigrams.remove(normalized)
 		}
-		indexTrie.Set(prefix, fileList)
+		if foldedTrie != nil {
+			foldedRemove(normalized)
+		}
+		return isDir
 	}
+	indexTrie.Set(prefix, fileList)
+	return isDir
 }
 
 func PrintMemUsage() {
@@ -235,10 +429,8 @@ func PrintMemUsage() {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
-	log.Println("Memory Statistics:")
-	log.Printf("\tAlloc = %v MiB", bToMb(m.Alloc))
-	log.Printf("\tTotalAlloc = %v MiB", bToMb(m.TotalAlloc))
-	log.Printf("\tSys = %v MiB", bToMb(m.Sys))
+	slog.Info("memory statistics", "alloc_mib", bToMb(m.Alloc),
+		"total_alloc_mib", bToMb(m.TotalAlloc), "sys_mib", bToMb(m.Sys))
 }
 func bToMb(b uint64) uint64 {
 	return b / 1024 / 1024
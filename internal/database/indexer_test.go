@@ -0,0 +1,124 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// commonNames are names that many directories contain
+var commonNames = []string{"index.js", "README.md", "__init__.py"}
+
+// indexProjects adds count directories below root to the index,
+// each containing the common names and a unique file
+func indexProjects(root string, count int) {
+	for i := 0; i < count; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("project%d", i))
+		indexTrieAdd(filepath.Base(dir), indexedFile{*fileTree.Add(dir), true})
+		names := append([]string{fmt.Sprintf("file%d", i)}, commonNames...)
+		for _, name := range names {
+			indexTrieAdd(name, indexedFile{*fileTree.Add(filepath.Join(dir, name)), false})
+		}
+	}
+}
+
+func TestRemoveFromIndex(t *testing.T) {
+	resetIndex()
+	indexProjects("/root", 100)
+
+	// remove every other project, the others must keep their entries
+	for i := 0; i < 100; i += 2 {
+		removeFromIndex("/root", fmt.Sprintf("project%d", i))
+	}
+	for _, name := range commonNames {
+		list, _ := indexTrie.Get([]byte(name)).([]indexedFile)
+		if len(list) != 50 {
+			t.Fatalf("%s is indexed %d times, want 50", name, len(list))
+		}
+		for _, file := range list {
+			var i int
+			path := file.pathNode.GetPath()
+			if _, err := fmt.Sscanf(path, "/root/project%d/", &i); err != nil || i%2 == 0 {
+				t.Errorf("%s is still indexed", path)
+			}
+		}
+	}
+	if item := indexTrie.Get([]byte("file0")); item != nil {
+		t.Errorf("file0 is still indexed")
+	}
+}
+
+func TestRefreshDirectory_Untracked(t *testing.T) {
+	root := tempTree(t, 2, 2)
+	defer os.RemoveAll(root)
+	defer func() { indexingDone = false }()
+	parallelWalk(root, 4)
+
+	// directories that aren't in the tree, e.g. because they were
+	// filtered, must stay out of the index after the walk
+	removeFromIndex(root, "dir0")
+	for _, dir := range []string{"dir0", "dir0/dir1"} {
+		path := filepath.Join(root, dir)
+		if err := ioutil.WriteFile(filepath.Join(path, "new"), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if changed := refreshDirectory(path); len(changed) != 0 {
+			t.Errorf("refreshing %s changed %v", dir, changed)
+		}
+	}
+	if paths := indexedPaths(root); paths[filepath.Join(root, "dir0")] {
+		t.Errorf("dir0 was indexed again")
+	}
+}
+
+func TestPing(t *testing.T) {
+	// nothing answers the ping without a running database
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Ping(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Ping() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	go func() { <-pingSender }()
+	if err := Ping(context.Background()); err != nil {
+		t.Errorf("Ping() = %v, want nil", err)
+	}
+}
+
+// BenchmarkRemoveFromIndex measures removing many directories that
+// contain files with common names at once, like during rm -rf
+func BenchmarkRemoveFromIndex(b *testing.B) {
+	const projects = 2000
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		resetIndex()
+		indexProjects("/root", projects)
+		b.StartTimer()
+
+		for j := 0; j < projects; j++ {
+			removeFromIndex("/root", fmt.Sprintf("project%d", j))
+		}
+	}
+}
+
+// BenchmarkRemoveFromIndex_Trigrams is BenchmarkRemoveFromIndex
+// with the trigram index, whose postings are updated as well
+func BenchmarkRemoveFromIndex_Trigrams(b *testing.B) {
+	const projects = 2000
+	defer func() { trigrams = nil }()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		resetIndex()
+		indexProjects("/root", projects)
+		trigrams = buildTrigramIndex()
+		b.StartTimer()
+
+		for j := 0; j < projects; j++ {
+			removeFromIndex("/root", fmt.Sprintf("project%d", j))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/metrics"
+)
+
+var resultBuckets = []float64{0, 1, 10, 100, 1000, 10000, 100000, 1000000}
+
+var (
+	queryDuration = metrics.NewHistogramVec("gosearch_query_duration_seconds",
+		"Time spent answering queries by action.", "action", metrics.DefaultBuckets)
+	queryResults = metrics.NewHistogramVec("gosearch_query_results",
+		"Number of results returned per query by action.", "action", resultBuckets)
+	refreshDuration = metrics.NewHistogram("gosearch_refresh_duration_seconds",
+		"Time spent refreshing a changed directory.", metrics.DefaultBuckets)
+	changesProcessed = metrics.NewCounter("gosearch_changes_processed_total",
+		"Number of file changes applied to the index.")
+	lastChange = metrics.NewGauge("gosearch_last_change_timestamp_seconds",
+		"Unix time at which the last file change was applied to the index.")
+	initialIndexDuration = metrics.NewGauge("gosearch_initial_index_duration_seconds",
+		"Time it took to build the initial index.")
+	indexedFiles = metrics.NewGauge("gosearch_index_files",
+		"Number of files in the index.")
+	indexedDirectories = metrics.NewGauge("gosearch_index_directories",
+		"Number of directories in the index.")
+	indexedNames = metrics.NewGauge("gosearch_index_names",
+		"Number of distinct names in the index trie.")
+)
+
+func countEntry(isDir bool, delta float64) {
+	if isDir {
+		indexedDirectories.Add(delta)
+	} else {
+		indexedFiles.Add(delta)
+	}
+}
+
+func observeSince(h *metrics.Histogram, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeName returns the NFC form of name, names are indexed and
+// queries are answered in this form, so names that are stored
+// decomposed (NFD), e.g. when they were copied from macOS,
+// are found by queries typed in either form
+func normalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// folder builds the keys by which names and queries are compared
+// in case or accent insensitive searches, it isn't safe for
+// concurrent use
+type folder struct {
+	caseInsensitive bool
+	transformer     transform.Transformer
+}
+
+func newFolder(caseInsensitive, accentInsensitive bool) *folder {
+	var transformers []transform.Transformer
+	if accentInsensitive {
+		// the accents are split from the letters and removed
+		transformers = append(transformers,
+			norm.NFD, runes.Remove(runes.In(unicode.Mn)))
+	}
+	if caseInsensitive {
+		// full case folding, e.g. ß matches ss
+		transformers = append(transformers, cases.Fold())
+	}
+	transformers = append(transformers, norm.NFC)
+	return &folder{caseInsensitive, transform.Chain(transformers...)}
+}
+
+// fold returns the key of s
+func (f *folder) fold(s string) string {
+	if isASCII(s) {
+		if f.caseInsensitive {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+	key, _, err := transform.String(f.transformer, s)
+	if err != nil {
+		return s
+	}
+	return key
+}
+
+// hasUpper reports whether s contains an upper-case letter,
+// smart case searches are only case sensitive if it does
+func hasUpper(s string) bool {
+	for _, r := range s {
+		if unicode.IsUpper(r) || unicode.IsTitle(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// literalsHaveUpper reports whether the literals of the regular
+// expression contain an upper-case letter, like hasUpper
+func literalsHaveUpper(re *syntax.Regexp) bool {
+	if re.Op == syntax.OpLiteral && hasUpper(string(re.Rune)) {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if literalsHaveUpper(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// nonASCIINames holds the names of the index that contain non-ASCII
+// characters, the trie only compares ASCII letters case insensitively,
+// so these names are compared with the query separately
+var nonASCIINames = make(map[string]bool)
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// fuzzyMatch reports whether the characters of query appear in name
+// in the same order, skipped is the number of characters of name
+// between the first and the last matched one that aren't matched,
+// like in the fuzzy search of the trie
+func fuzzyMatch(name, query string) (skipped int, ok bool) {
+	if query == "" {
+		return 0, true
+	}
+	next, size := utf8.DecodeRuneInString(query)
+	matched := 0
+	for _, r := range name {
+		if r != next {
+			if matched > 0 {
+				skipped++
+			}
+			continue
+		}
+		matched += size
+		if matched == len(query) {
+			return skipped, true
+		}
+		next, size = utf8.DecodeRuneInString(query[matched:])
+	}
+	return 0, false
+}
+
+// fuzzyPositions returns the byte offsets in path of the characters
+// of its name that the fuzzy search matched with query, the characters
+// are compared by their keys if f isn't nil, otherwise query has to be
+// normalized
+func fuzzyPositions(path, query string, f *folder) []int {
+	var positions []int
+	// the characters are compared with their combining marks,
+	// as the name may be decomposed
+	for offset := strings.LastIndexByte(path, '/') + 1; offset < len(path) && query != ""; {
+		n := norm.NFC.NextBoundaryInString(path[offset:], true)
+		key := normalizeName(path[offset : offset+n])
+		if f != nil {
+			key = f.fold(key)
+		}
+
+		matched := false
+		for _, r := range key {
+			next, size := utf8.DecodeRuneInString(query)
+			if r == next {
+				query = query[size:]
+				matched = true
+				if query == "" {
+					break
+				}
+			}
+		}
+		if matched {
+			positions = append(positions, offset)
+		}
+		offset += n
+	}
+	return positions
+}
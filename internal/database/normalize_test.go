@@ -0,0 +1,143 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ozeidan/gosearch/internal/request"
+)
+
+func TestFolder_Fold(t *testing.T) {
+	tests := []struct {
+		name              string
+		caseInsensitive   bool
+		accentInsensitive bool
+		s                 string
+		want              string
+	}{
+		{"ascii", false, false, "README.md", "README.md"},
+		{"ascii_case", true, false, "README.md", "readme.md"},
+		{"decomposed", false, false, "re\u0301sume\u0301", "résumé"},
+		{"case", true, false, "RÉSUMÉ", "résumé"},
+		{"full_case_folding", true, false, "Straße", "strasse"},
+		{"accents", false, true, "Résumé", "Resume"},
+		{"decomposed_accents", false, true, "re\u0301sume\u0301", "resume"},
+		{"case_accents", true, true, "ÅNGSTRÖM", "angstrom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFolder(tt.caseInsensitive, tt.accentInsensitive)
+			if got := f.fold(tt.s); got != tt.want {
+				t.Errorf("fold(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		name, query string
+		wantSkipped int
+		wantOk      bool
+	}{
+		{"gosearch", "grch", 4, true},
+		{"gosearch", "gosearch", 0, true},
+		{"gosearch", "", 0, true},
+		{"gosearch", "rg", 0, false},
+		{"xxgosearch", "go", 0, true},
+		{"résumé.pdf", "rép", 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"_"+tt.query, func(t *testing.T) {
+			skipped, ok := fuzzyMatch(tt.name, tt.query)
+			if skipped != tt.wantSkipped || ok != tt.wantOk {
+				t.Errorf("fuzzyMatch() = %d, %v, want %d, %v",
+					skipped, ok, tt.wantSkipped, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestQueryIndex_Normalization(t *testing.T) {
+	defer resetWalkedIndex()()
+	names := []string{
+		// decomposed, as created by macOS
+		"re\u0301sume\u0301.pdf",
+		"Resume.txt",
+		"Straße.md",
+		"Ångström",
+	}
+	for _, name := range names {
+		indexTrieAdd(name, indexedFile{*fileTree.Add("/root/" + name), false})
+	}
+
+	tests := []struct {
+		name     string
+		action   int
+		query    string
+		settings request.Settings
+		want     []string
+	}{
+		{"composed_query", request.SubStringSearch, "résumé",
+			request.Settings{}, []string{"/root/re\u0301sume\u0301.pdf"}},
+		{"decomposed_query", request.PrefixSearch, "re\u0301s",
+			request.Settings{}, []string{"/root/re\u0301sume\u0301.pdf"}},
+		{"case_insensitive", request.SubStringSearch, "RÉSUM",
+			request.Settings{CaseInsensitive: true}, []string{"/root/re\u0301sume\u0301.pdf"}},
+		{"full_case_folding", request.SubStringSearch, "STRASSE",
+			request.Settings{CaseInsensitive: true}, []string{"/root/Straße.md"}},
+		{"accent_insensitive", request.SubStringSearch, "esume",
+			request.Settings{AccentInsensitive: true},
+			[]string{"/root/Resume.txt", "/root/re\u0301sume\u0301.pdf"}},
+		{"accent_and_case_insensitive", request.FuzzySearch, "angstrm",
+			request.Settings{CaseInsensitive: true, AccentInsensitive: true},
+			[]string{"/root/Ångström"}},
+		{"regex", request.RegexSearch, `^resume\.`,
+			request.Settings{CaseInsensitive: true, AccentInsensitive: true},
+			[]string{"/root/Resume.txt", "/root/re\u0301sume\u0301.pdf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.settings.Action = tt.action
+			tt.settings.NoSort = true
+			withIndex, without := querySettingsWith(tt.query, tt.settings)
+			if !reflect.DeepEqual(without, tt.want) {
+				t.Errorf("query returned %q, want %q", without, tt.want)
+			}
+			if !reflect.DeepEqual(withIndex, tt.want) {
+				t.Errorf("query with the trigram index returned %q, want %q", withIndex, tt.want)
+			}
+		})
+	}
+
+	// decomposed names are removed from the normalized index
+	removeFromIndex("/root", "re\u0301sume\u0301.pdf")
+	if item := indexTrie.Get([]byte("résumé.pdf")); item != nil {
+		t.Errorf("the removed name is still indexed")
+	}
+}
+
+func TestFuzzyPositions(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		query string
+		f     *folder
+		want  []int
+	}{
+		{"name_only", "/grch/gosearch", "grch", nil, []int{6, 11, 12, 13}},
+		{"no_match", "/root/gosearch", "x", nil, nil},
+		{"case_insensitive", "/root/GoSearch", "gs", newFolder(true, false), []int{6, 8}},
+		{"multi_byte", "/root/résumé", "rsé", nil, []int{6, 9, 12}},
+		{"decomposed", "/root/re\u0301sume\u0301", "ré", nil, []int{6, 7}},
+		{"accent_insensitive", "/root/re\u0301sume\u0301", "rse", newFolder(false, true), []int{6, 10, 13}},
+		{"full_case_folding", "/root/Straße", "ss", newFolder(true, false), []int{6, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fuzzyPositions(tt.path, tt.query, tt.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fuzzyPositions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
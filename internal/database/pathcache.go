@@ -0,0 +1,82 @@
+package database
+
+import (
+	"container/list"
+
+	"github.com/ozeidan/gosearch/pkg/tree"
+)
+
+// pathCache holds the paths of the directories that were returned
+// most recently, so the paths of directories that are part of many
+// results aren't built again for every query
+type pathCache struct {
+	size  int
+	order *list.List
+	paths map[tree.Node]*list.Element
+	// moves is the move count of the tree when the paths were cached,
+	// moving entries changes the paths of the entries below them
+	moves uint64
+}
+
+type cachedPath struct {
+	node tree.Node
+	path string
+}
+
+// paths is nil when caching is disabled
+var paths *pathCache
+
+func newPathCache(size int) *pathCache {
+	if size <= 0 {
+		return nil
+	}
+	return &pathCache{size: size, order: list.New(), paths: make(map[tree.Node]*list.Element)}
+}
+
+// resizePathCache applies the configured cache size,
+// keeping the cached paths if the cache stays enabled
+func resizePathCache(size int) {
+	if size <= 0 || paths == nil {
+		paths = newPathCache(size)
+		return
+	}
+	paths.size = size
+	paths.evict()
+}
+
+// get returns the path of node, building and caching it if needed,
+// handles of deleted entries never match a cached path, as the
+// entries reusing their slots have a different generation
+// the cache is dropped once entries of the tree were moved
+func (c *pathCache) get(node tree.Node) string {
+	if moves := node.Moves(); moves != c.moves {
+		c.order.Init()
+		c.paths = make(map[tree.Node]*list.Element)
+		c.moves = moves
+	}
+	if element, ok := c.paths[node]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(cachedPath).path
+	}
+
+	path := node.GetPath()
+	c.paths[node] = c.order.PushFront(cachedPath{node, path})
+	c.evict()
+	return path
+}
+
+func (c *pathCache) evict() {
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.paths, oldest.Value.(cachedPath).node)
+	}
+}
+
+// pathOf returns the path of file, using the cache for directories
+func pathOf(file indexedFile) string {
+	if paths == nil || !file.isDir {
+		return file.pathNode.GetPath()
+	}
+	return paths.get(file.pathNode)
+}
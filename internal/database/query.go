@@ -1,8 +1,11 @@
 package database
 
 import (
-	"log"
+	"log/slog"
+	"regexp"
+	"regexp/syntax"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/ozeidan/gosearch/internal/request"
@@ -14,83 +17,195 @@ type resulter interface {
 	sort.Interface
 }
 
-type sortResult struct {
-	result  string
+// queryResult is a match of a query, the length of its path is
+// used for ranking, the path itself is only built for the matches
+// that are sent
+type queryResult struct {
+	file    indexedFile
+	length  int
 	skipped int
 }
 
-type bySkipped []sortResult
+func newQueryResult(file indexedFile, skipped int) queryResult {
+	return queryResult{file, file.pathNode.PathLen(), skipped}
+}
+
+type bySkipped []queryResult
 
 func (s bySkipped) Len() int      { return len(s) }
 func (s bySkipped) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s bySkipped) Less(i, j int) bool {
 	if s[i].skipped == s[j].skipped {
-		return len(s[i].result) < len(s[j].result)
+		return s[i].length < s[j].length
 	}
 	return s[i].skipped < s[j].skipped
 }
 func (s bySkipped) Result(index int) string {
-	return s[index].result
+	return pathOf(s[index].file)
 }
 
-type byLength []string
+type byLength []queryResult
 
 func (l byLength) Len() int           { return len(l) }
 func (l byLength) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
-func (l byLength) Less(i, j int) bool { return len(l[i]) < len(l[j]) }
+func (l byLength) Less(i, j int) bool { return l[i].length < l[j].length }
 func (l byLength) Result(index int) string {
-	return l[index]
+	return pathOf(l[index].file)
 }
 
 func queryIndex(req request.Request) {
 	defer close(req.ResponseChannel)
-	log.Println("querying", req.Query)
-	prefix := trie.Prefix(req.Query)
+	if req.Settings.Action == request.Status {
+		sendStatus(req)
+		return
+	}
+
+	action := request.ActionName(req.Settings.Action)
+	slog.Debug("received query", "action", action, "query", req.Query)
+	query := normalizeName(req.Query)
+	prefix := trie.Prefix(query)
+	caseInsensitive := req.Settings.CaseInsensitive ||
+		req.Settings.SmartCase && !hasUpper(query)
+	// case and accent insensitive searches compare the keys of the names
+	var f *folder
+	if caseInsensitive || req.Settings.AccentInsensitive {
+		f = newFolder(caseInsensitive, req.Settings.AccentInsensitive)
+	}
+	// searches that are only case insensitive use the folded trie,
+	// if it is enabled
+	folded := foldedTrie != nil && caseInsensitive && !req.Settings.AccentInsensitive
+	// otherwise the trie finds the ASCII names for ASCII queries,
+	// only other queries and accent insensitive ones fold every name
+	trieFolding := caseInsensitive && !req.Settings.AccentInsensitive && isASCII(query)
+	defer observeSince(queryDuration.With(action), time.Now())
 
 	var results resulter
+	// matches returns the positions of the matched characters in the
+	// path of a result, if the client wants to highlight them
+	var matches func(path string) []int
 
 	start := logStart("query")
 	switch req.Settings.Action {
 	case request.PrefixSearch:
 		tempResults := byLength{}
-		indexTrie.VisitSubtree(prefix, func(prefix trie.Prefix, item trie.Item) error {
+		visitor := func(prefix trie.Prefix, item trie.Item) error {
 			list := item.([]indexedFile)
 			for _, file := range list {
-				tempResults = append(tempResults,
-					file.pathNode.GetPath())
+				tempResults = append(tempResults, newQueryResult(file, 0))
 			}
 			return nil
-		})
+		}
+		switch {
+		case folded:
+			foldedTrie.VisitSubtree(trie.Prefix(f.fold(query)), foldedVisitor(visitor))
+		case trieFolding:
+			visitPrefixFolded(query, f, visitor)
+		case f != nil:
+			visitMatches([]string{query}, prefixMatcher(query, f), visitor)
+		default:
+			indexTrie.VisitSubtree(prefix, visitor)
+		}
 
-		results = byLength(tempResults)
+		results = tempResults
 	case request.SubStringSearch:
 		tempResults := byLength{}
-		indexTrie.VisitSubstring(prefix, req.Settings.CaseInsensitive,
+		visitor := func(prefix trie.Prefix, item trie.Item) error {
+			list := item.([]indexedFile)
+			for _, file := range list {
+				tempResults = append(tempResults, newQueryResult(file, 0))
+			}
+			return nil
+		}
+		switch {
+		case folded && (trigrams == nil || len(query) < 3):
+			foldedTrie.VisitSubstring(trie.Prefix(f.fold(query)), false, foldedVisitor(visitor))
+		case trigrams != nil || f != nil && !trieFolding:
+			visitMatches([]string{query}, substringMatcher(query, f), visitor)
+		case trieFolding:
+			visitSubstringFolded(query, f, visitor)
+		default:
+			indexTrie.VisitSubstring(prefix, false, visitor)
+		}
+
+		results = tempResults
+	case request.RegexSearch:
+		expr := query
+		// the accents are removed from the expression and the names
+		accents := newFolder(false, true)
+		if req.Settings.AccentInsensitive {
+			expr = accents.fold(expr)
+		}
+		parsed, err := syntax.Parse(expr, syntax.Perl)
+		if err != nil {
+			req.Send(request.Response{Notice: "invalid regular expression: " + err.Error()})
+			return
+		}
+		// smart case only looks at the literals, so escapes
+		// like \W don't make the search case sensitive
+		if req.Settings.CaseInsensitive ||
+			req.Settings.SmartCase && !literalsHaveUpper(parsed) {
+			expr = "(?i)" + expr
+			parsed, _ = syntax.Parse(expr, syntax.Perl)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			req.Send(request.Response{Notice: "invalid regular expression: " + err.Error()})
+			return
+		}
+
+		match := re.MatchString
+		if req.Settings.AccentInsensitive {
+			match = func(name string) bool { return re.MatchString(accents.fold(name)) }
+		}
+
+		tempResults := byLength{}
+		visitMatches(requiredLiterals(parsed.Simplify()), match,
 			func(prefix trie.Prefix, item trie.Item) error {
 				list := item.([]indexedFile)
 				for _, file := range list {
-					tempResults = append(tempResults,
-						file.pathNode.GetPath())
+					tempResults = append(tempResults, newQueryResult(file, 0))
 				}
 				return nil
 			})
 
-		results = byLength(tempResults)
+		results = tempResults
 	case request.FuzzySearch:
-		tempResults := []sortResult{}
-		indexTrie.VisitFuzzy(prefix, req.Settings.CaseInsensitive,
-			func(prefix trie.Prefix, item trie.Item, skipped int) error {
-				list := item.([]indexedFile)
-				for _, file := range list {
-					tempResults = append(tempResults,
-						sortResult{file.pathNode.GetPath(), skipped})
-				}
-				return nil
-			})
+		tempResults := bySkipped{}
+		visitor := func(prefix trie.Prefix, item trie.Item, skipped int) error {
+			list := item.([]indexedFile)
+			for _, file := range list {
+				tempResults = append(tempResults, newQueryResult(file, skipped))
+			}
+			return nil
+		}
+		if req.Settings.Structured {
+			key := query
+			if f != nil {
+				key = f.fold(query)
+			}
+			matches = func(path string) []int {
+				return fuzzyPositions(path, key, f)
+			}
+		}
+		switch {
+		case folded:
+			foldedTrie.VisitFuzzy(trie.Prefix(f.fold(query)), false, foldedFuzzyVisitor(visitor))
+		case trieFolding:
+			visitFuzzyFolded(query, f, visitor)
+		case f != nil:
+			visitFuzzy(query, f, visitor)
+		default:
+			indexTrie.VisitFuzzy(prefix, false, visitor)
+		}
 
-		results = bySkipped(tempResults)
+		results = tempResults
+	}
+	logStop("query", start)
+
+	if results == nil {
+		req.Send(request.Response{Notice: "unsupported action " + action})
+		return
 	}
-	logStop(start)
 
 	if !req.Settings.NoSort {
 		start = logStart("sort")
@@ -99,17 +214,177 @@ func queryIndex(req request.Request) {
 		} else {
 			sort.Sort(sort.Reverse(results))
 		}
-		logStop(start)
+		logStop("sort", start)
+	}
+
+	if !indexingDone {
+		// plain text clients only learn about partial results
+		// from the status, Send drops the notice for them
+		req.Send(request.Response{Notice: partialNotice, Partial: true})
+	}
+	sendResults(results, matches, req)
+}
+
+// visitMatches calls visitor for the names in the index that match,
+// the names are narrowed down to the ones containing all literals
+// by the trigram index if it is enabled
+func visitMatches(literals []string, match func(name string) bool, visitor trie.VisitorFunc) {
+	var names []string
+	narrowed := false
+	if trigrams != nil {
+		names, narrowed = trigrams.candidates(literals)
+	}
+	if !narrowed {
+		indexTrie.Visit(func(prefix trie.Prefix, item trie.Item) error {
+			if match(string(prefix)) {
+				return visitor(prefix, item)
+			}
+			return nil
+		})
+		return
+	}
+
+	for _, name := range names {
+		if !match(name) {
+			continue
+		}
+		prefix := trie.Prefix(name)
+		if item := indexTrie.Get(prefix); item != nil {
+			visitor(prefix, item)
+		}
+	}
+}
+
+// substringMatcher returns a function that reports whether a name
+// contains query, comparing their keys if f isn't nil
+func substringMatcher(query string, f *folder) func(name string) bool {
+	if f == nil {
+		return func(name string) bool {
+			return strings.Contains(name, query)
+		}
+	}
+	query = f.fold(query)
+	return func(name string) bool {
+		return strings.Contains(f.fold(name), query)
 	}
+}
+
+// prefixMatcher returns a function that reports whether
+// the key of a name starts with the key of query
+func prefixMatcher(query string, f *folder) func(name string) bool {
+	query = f.fold(query)
+	return func(name string) bool {
+		return strings.HasPrefix(f.fold(name), query)
+	}
+}
+
+// visitFuzzy calls visitor for the names whose keys match
+// the key of query in the fuzzy search
+func visitFuzzy(query string, f *folder, visitor trie.FuzzyVisitorFunc) {
+	query = f.fold(query)
+	indexTrie.Visit(func(prefix trie.Prefix, item trie.Item) error {
+		if skipped, ok := fuzzyMatch(f.fold(string(prefix)), query); ok {
+			return visitor(prefix, item, skipped)
+		}
+		return nil
+	})
+}
 
-	sendResults(results, req)
+// visitSubstringFolded calls visitor for the names whose keys contain
+// the key of the ASCII query, the trie finds the ASCII names
+// case insensitively, only the names in nonASCIINames are folded
+func visitSubstringFolded(query string, f *folder, visitor trie.VisitorFunc) {
+	match := substringMatcher(query, f)
+	indexTrie.VisitSubstring(trie.Prefix(query), true, func(prefix trie.Prefix, item trie.Item) error {
+		// the trie also matches some punctuation case insensitively
+		if name := string(prefix); isASCII(name) && match(name) {
+			return visitor(prefix, item)
+		}
+		return nil
+	})
+	for name := range nonASCIINames {
+		if match(name) {
+			prefix := trie.Prefix(name)
+			visitor(prefix, indexTrie.Get(prefix))
+		}
+	}
 }
 
-func sendResults(results resulter, req request.Request) {
+// visitPrefixFolded calls visitor for the names whose keys start with
+// the key of the ASCII query, the trie finds the ASCII names by trying
+// both cases of each letter of the query and dropping the prefixes that
+// no name starts with, only the names in nonASCIINames are folded
+func visitPrefixFolded(query string, f *folder, visitor trie.VisitorFunc) {
+	prefixes := []trie.Prefix{{}}
+	for i := 0; i < len(query); i++ {
+		var next []trie.Prefix
+		for _, p := range prefixes {
+			for _, c := range asciiCases(query[i]) {
+				extended := append(p[:len(p):len(p)], c)
+				if indexTrie.MatchSubtree(extended) {
+					next = append(next, extended)
+				}
+			}
+		}
+		prefixes = next
+	}
+	for _, p := range prefixes {
+		indexTrie.VisitSubtree(p, func(prefix trie.Prefix, item trie.Item) error {
+			if isASCII(string(prefix)) {
+				return visitor(prefix, item)
+			}
+			return nil
+		})
+	}
+
+	match := prefixMatcher(query, f)
+	for name := range nonASCIINames {
+		if match(name) {
+			prefix := trie.Prefix(name)
+			visitor(prefix, indexTrie.Get(prefix))
+		}
+	}
+}
+
+// asciiCases returns the lower and upper case of an ASCII letter,
+// or only c if it isn't one
+func asciiCases(c byte) []byte {
+	switch {
+	case 'a' <= c && c <= 'z':
+		return []byte{c, c - 'a' + 'A'}
+	case 'A' <= c && c <= 'Z':
+		return []byte{c - 'A' + 'a', c}
+	}
+	return []byte{c}
+}
+
+// visitFuzzyFolded is visitSubstringFolded for fuzzy searches
+func visitFuzzyFolded(query string, f *folder, visitor trie.FuzzyVisitorFunc) {
+	key := f.fold(query)
+	indexTrie.VisitFuzzy(trie.Prefix(query), true, func(prefix trie.Prefix, item trie.Item, _ int) error {
+		name := string(prefix)
+		if !isASCII(name) {
+			return nil
+		}
+		if skipped, ok := fuzzyMatch(f.fold(name), key); ok {
+			return visitor(prefix, item, skipped)
+		}
+		return nil
+	})
+	for name := range nonASCIINames {
+		if skipped, ok := fuzzyMatch(f.fold(name), key); ok {
+			prefix := trie.Prefix(name)
+			visitor(prefix, indexTrie.Get(prefix), skipped)
+		}
+	}
+}
+
+func sendResults(results resulter, matches func(path string) []int, req request.Request) {
 	maxResults := req.Settings.MaxResults
 	if maxResults == 0 || maxResults > results.Len() {
 		maxResults = results.Len()
 	}
+	queryResults.With(request.ActionName(req.Settings.Action)).Observe(float64(maxResults))
 
 	var startIndex int
 
@@ -120,19 +395,25 @@ func sendResults(results resulter, req request.Request) {
 	}
 
 	for i := startIndex; i < startIndex+maxResults; i++ {
-		select {
-		case req.ResponseChannel <- results.Result(i):
-		case <-req.Done:
+		response := request.Response{Path: results.Result(i), Partial: !indexingDone}
+		if matches != nil {
+			response.Matches = matches(response.Path)
+		}
+		if !req.Send(response) {
 			return
 		}
 	}
 }
 
-func logStart(action string) time.Time {
-	log.Println("starting to", action)
+func logStart(step string) time.Time {
+	slog.Debug("starting to "+step, "step", step)
 	return time.Now()
 }
 
-func logStop(start time.Time) {
-	log.Println("done in", time.Now().Sub(start))
+func logStop(step string, start time.Time) {
+	slog.Debug("finished "+step, "step", step, "duration", time.Since(start))
 }
+
+// partialNotice is sent in response to queries that are answered
+// before the initial index is built
+const partialNotice = "indexing in progress, results may be incomplete"
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/ozeidan/gosearch/internal/request"
+	trie "gopkg.in/ozeidan/fuzzy-patricia.v3/patricia"
+)
+
+// query sends a query to the index and returns the lines of the response
+func query(q string, settings request.Settings) []string {
+	req := request.Request{
+		Query:           q,
+		Settings:        settings,
+		ResponseChannel: make(chan string),
+		Done:            make(chan struct{}),
+	}
+	go queryIndex(req)
+
+	var results []string
+	for result := range req.ResponseChannel {
+		results = append(results, result)
+	}
+	return results
+}
+
+// sortedQuery is query for tests that don't check the ranking
+func sortedQuery(q string, settings request.Settings) []string {
+	results := query(q, settings)
+	sort.Strings(results)
+	return results
+}
+
+// queryResponses is query for structured responses,
+// it returns the parsed responses
+func queryResponses(tb testing.TB, q string, settings request.Settings) []request.Response {
+	settings.Structured = true
+	var responses []request.Response
+	for _, line := range query(q, settings) {
+		var response request.Response
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			tb.Fatal(err)
+		}
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+func TestQueryIndex_PathCache(t *testing.T) {
+	defer func() { paths = nil }()
+	defer resetWalkedIndex()()
+	indexProjects("/root", 20)
+
+	substring := request.Settings{Action: request.SubStringSearch}
+	want := query("project1", substring)
+	for _, size := range []int{0, 1, 100} {
+		t.Run(fmt.Sprintf("size_%d", size), func(t *testing.T) {
+			resizePathCache(size)
+			// the second query is answered from the cache
+			for i := 0; i < 2; i++ {
+				if got := query("project1", substring); !reflect.DeepEqual(got, want) {
+					t.Errorf("query returned %v, want %v", got, want)
+				}
+			}
+			if size > 0 && paths.order.Len() > size {
+				t.Errorf("the cache holds %d paths, want at most %d", paths.order.Len(), size)
+			}
+		})
+	}
+
+	// the paths of removed directories aren't returned for
+	// the directories that reuse their tree nodes
+	resizePathCache(100)
+	indexTrieAdd("empty", indexedFile{*fileTree.Add("/root/empty"), true})
+	prefix := request.Settings{Action: request.PrefixSearch}
+	query("empty", prefix)
+	removeFromIndex("/root", "empty")
+	indexTrieAdd("renamed", indexedFile{*fileTree.Add("/root/renamed"), true})
+	if got := query("renamed", prefix); len(got) != 1 || got[0] != "/root/renamed" {
+		t.Errorf("query returned %v, want /root/renamed", got)
+	}
+
+	// the cached paths of moved directories are built again
+	query("project7", prefix)
+	fileTree.Add("/root/other")
+	if err := fileTree.Move("/root/project7", "/root/other/project7"); err != nil {
+		t.Fatal(err)
+	}
+	if got := query("project7", prefix); len(got) != 1 || got[0] != "/root/other/project7" {
+		t.Errorf("query returned %v, want /root/other/project7", got)
+	}
+}
+
+// BenchmarkQueryIndex measures a query matching
+// many more files than are returned
+func BenchmarkQueryIndex(b *testing.B) {
+	defer resetWalkedIndex()()
+	indexProjects("/root/some/deeply/nested/directory", 20000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		query("index", request.Settings{Action: request.SubStringSearch, MaxResults: 100})
+	}
+}
+
+func TestQueryIndex_SmartCase(t *testing.T) {
+	defer resetWalkedIndex()()
+	for _, name := range []string{"README.md", "readme.txt", "Makefile"} {
+		indexTrieAdd(name, indexedFile{*fileTree.Add("/root/" + name), false})
+	}
+
+	tests := []struct {
+		name     string
+		action   int
+		query    string
+		settings request.Settings
+		want     []string
+	}{
+		{"lower_case", request.SubStringSearch, "readme",
+			request.Settings{SmartCase: true}, []string{"/root/README.md", "/root/readme.txt"}},
+		{"upper_case", request.SubStringSearch, "README",
+			request.Settings{SmartCase: true}, []string{"/root/README.md"}},
+		{"case_insensitive", request.SubStringSearch, "README",
+			request.Settings{SmartCase: true, CaseInsensitive: true},
+			[]string{"/root/README.md", "/root/readme.txt"}},
+		{"case_sensitive", request.SubStringSearch, "readme",
+			request.Settings{}, []string{"/root/readme.txt"}},
+		{"prefix", request.PrefixSearch, "make",
+			request.Settings{SmartCase: true}, []string{"/root/Makefile"}},
+		{"prefix_upper_case", request.PrefixSearch, "Read",
+			request.Settings{SmartCase: true}, nil},
+		{"prefix_case_insensitive", request.PrefixSearch, "READ",
+			request.Settings{CaseInsensitive: true},
+			[]string{"/root/README.md", "/root/readme.txt"}},
+		{"fuzzy", request.FuzzySearch, "mkf",
+			request.Settings{SmartCase: true}, []string{"/root/Makefile"}},
+		{"fuzzy_upper_case", request.FuzzySearch, "rMd",
+			request.Settings{SmartCase: true}, nil},
+		// only the literals of regular expressions are checked
+		{"regex", request.RegexSearch, `^read\S+`,
+			request.Settings{SmartCase: true}, []string{"/root/README.md", "/root/readme.txt"}},
+		{"regex_upper_case", request.RegexSearch, `^READ\S+`,
+			request.Settings{SmartCase: true}, []string{"/root/README.md"}},
+		{"regex_case_sensitive", request.RegexSearch, `^read\S+`,
+			request.Settings{}, []string{"/root/readme.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.settings.Action = tt.action
+			withIndex, without := querySettingsWith(tt.query, tt.settings)
+			if !reflect.DeepEqual(without, tt.want) {
+				t.Errorf("query returned %q, want %q", without, tt.want)
+			}
+			if !reflect.DeepEqual(withIndex, tt.want) {
+				t.Errorf("query with the trigram index returned %q, want %q", withIndex, tt.want)
+			}
+		})
+	}
+}
+
+// TestVisitFolded checks that the case insensitive searches of the trie
+// find the same names as comparing the keys of every name
+func TestVisitFolded(t *testing.T) {
+	defer resetWalkedIndex()()
+	for _, name := range []string{"README.md", "read_me", "Straße", "KELVIN", "\u212Aelvin", "ReadMe", "{x}", "[x]"} {
+		indexTrieAdd(name, indexedFile{*fileTree.Add("/root/" + name), false})
+	}
+	f := newFolder(true, false)
+	names := func(visit func(visitor trie.VisitorFunc)) []string {
+		var names []string
+		visit(func(prefix trie.Prefix, item trie.Item) error {
+			names = append(names, string(prefix))
+			return nil
+		})
+		sort.Strings(names)
+		return names
+	}
+	fuzzyNames := func(visit func(visitor trie.FuzzyVisitorFunc)) []string {
+		return names(func(visitor trie.VisitorFunc) {
+			visit(func(prefix trie.Prefix, item trie.Item, skipped int) error {
+				return visitor(prefix, item)
+			})
+		})
+	}
+
+	for _, q := range []string{"read", "strass", "kelvin", "[x", "e", "rdm", "sse"} {
+		t.Run(q, func(t *testing.T) {
+			want := names(func(visitor trie.VisitorFunc) {
+				visitMatches([]string{q}, substringMatcher(q, f), visitor)
+			})
+			got := names(func(visitor trie.VisitorFunc) {
+				visitSubstringFolded(q, f, visitor)
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("substring search returned %q, want %q", got, want)
+			}
+
+			want = names(func(visitor trie.VisitorFunc) {
+				visitMatches([]string{q}, prefixMatcher(q, f), visitor)
+			})
+			got = names(func(visitor trie.VisitorFunc) {
+				visitPrefixFolded(q, f, visitor)
+			})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("prefix search returned %q, want %q", got, want)
+			}
+
+			want = fuzzyNames(func(visitor trie.FuzzyVisitorFunc) { visitFuzzy(q, f, visitor) })
+			got = fuzzyNames(func(visitor trie.FuzzyVisitorFunc) { visitFuzzyFolded(q, f, visitor) })
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("fuzzy search returned %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestQueryIndex_FuzzyMatches(t *testing.T) {
+	defer resetWalkedIndex()()
+	for _, name := range []string{"gosearch", "Makefile"} {
+		indexTrieAdd(name, indexedFile{*fileTree.Add("/root/" + name), false})
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		settings request.Settings
+		want     []int
+	}{
+		{"fuzzy", "grch", request.Settings{Action: request.FuzzySearch}, []int{6, 11, 12, 13}},
+		{"case_insensitive", "MKF", request.Settings{Action: request.FuzzySearch, CaseInsensitive: true},
+			[]int{6, 8, 10}},
+		{"substring", "sea", request.Settings{Action: request.SubStringSearch}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := queryResponses(t, tt.query, tt.settings)
+			if len(responses) != 1 {
+				t.Fatalf("got %d responses, want 1", len(responses))
+			}
+			if got := responses[0].Matches; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s was matched at %v, want %v", responses[0].Path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/watcher"
+	"github.com/ozeidan/gosearch/pkg/tree"
+)
+
+// ReloadResult describes the outcome of the last configuration reload
+type ReloadResult struct {
+	// Time is the time at which the reload was requested
+	Time time.Time `json:"time"`
+	// Error holds the reason why the configuration couldn't be reloaded
+	Error string `json:"error,omitempty"`
+	// RootsChanged is set when the watchers have to be recreated
+	RootsChanged bool `json:"roots_changed"`
+	// Rescan is set when new roots and the directories that contained
+	// filtered entries are read again to find entries that aren't
+	// filtered anymore
+	Rescan bool `json:"rescan"`
+	// InProgress is set while the index is reconciled
+	// with the new configuration
+	InProgress bool `json:"in_progress"`
+	// Added is the amount of entries that were added to the index
+	Added int `json:"added"`
+	// Removed is the amount of entries that were removed from the index
+	Removed int `json:"removed"`
+}
+
+// the amount of directories that are reconciled
+// before handling other events again
+const reconcileBatchSize = 200
+
+var reloadSender = make(chan chan ReloadResult)
+var watcherSender = make(chan watcher.Watcher)
+
+var alwaysReady = make(chan struct{})
+
+func init() {
+	close(alwaysReady)
+}
+
+var lastReload *ReloadResult
+var reconcileQueue []string
+
+// filteredDirectories holds the tree nodes of the directories that
+// contained filtered entries when they were read last, only these are
+// read again when a reload may have stopped filtering some entries
+var filteredDirectories = make(map[tree.Node]bool)
+
+// Reload reloads the configuration and starts to reconcile the index
+// with it, queries are answered while the reconciliation is running
+// it returns as soon as the new configuration is in effect
+func Reload() ReloadResult {
+	resultReceiver := make(chan ReloadResult)
+	reloadSender <- resultReceiver
+	return <-resultReceiver
+}
+
+// SetWatcher replaces the watcher that reports the file changes
+// the previous watcher is closed
+func SetWatcher(w watcher.Watcher) {
+	watcherSender <- w
+}
+
+func reload() ReloadResult {
+	result := &ReloadResult{Time: time.Now()}
+	lastReload = result
+
+	summary, err := config.Reload()
+	if err != nil {
+		slog.Error("failed to reload the configuration", "err", err)
+		result.Error = err.Error()
+		return *result
+	}
+
+	if !config.UseIgnoreFiles() {
+		ignoreRules = make(map[tree.Node]ignoreFiles)
+	}
+	resizePathCache(config.PathCacheSize())
+	if !config.TrigramIndex() {
+		trigrams = nil
+	} else if trigrams == nil {
+		trigrams = buildTrigramIndex()
+	}
+	if !config.CaseInsensitiveIndex() {
+		foldedTrie = nil
+	} else if foldedTrie == nil {
+		buildFoldedTrie()
+	}
+
+	result.RootsChanged = summary.RootsChanged
+	result.Rescan = summary.Loosened
+	result.InProgress = true
+	reconcileQueue = append(reconcileQueue[:0], "/")
+	if result.Rescan {
+		// new roots aren't reached from the directories in the index
+		for _, root := range config.RemoveNested(config.Roots()) {
+			if _, err := fileTree.Find(root); err != nil {
+				reconcileQueue = append(reconcileQueue, root)
+			}
+		}
+	}
+
+	slog.Info("reloaded configuration, reconciling the index", "rescan", result.Rescan)
+	return *result
+}
+
+func reconcileBatch() {
+	for i := 0; i < reconcileBatchSize && len(reconcileQueue) > 0; i++ {
+		last := len(reconcileQueue) - 1
+		path := reconcileQueue[last]
+		reconcileQueue = reconcileQueue[:last]
+		reconcileDirectory(path)
+	}
+
+	// the directories that were added are read by the walk,
+	// the reconciliation is finished once the walk is done
+	if len(reconcileQueue) == 0 && activeWalk == nil {
+		lastReload.InProgress = false
+		slog.Info("finished reconciling the index",
+			"added", lastReload.Added, "removed", lastReload.Removed)
+	}
+}
+
+// reconcileDirectory removes the entries of the directory at path that
+// are filtered now, its subdirectories are queued for reconciliation,
+// when rescanning it also adds the entries that aren't filtered anymore
+func reconcileDirectory(path string) {
+	// the ignore files are only parsed if they changed,
+	// or if use_ignore_files was switched on
+	loadIgnoreFiles(path)
+
+	node, err := fileTree.Find(path)
+	switch {
+	case err != nil && lastReload.Rescan && config.IsRoot(path):
+		addRoot(path)
+		return
+	case err != nil:
+		return
+	case lastReload.Rescan && filteredDirectories[*node]:
+		rescanDirectory(path)
+		return
+	}
+
+	children, _ := node.GetChildren("/")
+	entries := newEntryFilter(path)
+	for _, name := range children {
+		childPath := filepath.Join(path, name)
+		if entries.isFiltered(childPath, isIndexedDir(childPath)) {
+			removeFromIndex(path, name)
+			lastReload.Removed++
+			continue
+		}
+		reconcileQueue = append(reconcileQueue, childPath)
+	}
+}
+
+// addRoot queues the root at path that was added by the reload
+// on the walk, which indexes it
+func addRoot(path string) {
+	if isEntryFiltered(path, true) {
+		return
+	}
+	walkDirectory(path)
+	lastReload.Added++
+}
+
+// rescanDirectory reads the directory at path, which contained filtered
+// entries, again, so the entries that aren't filtered anymore are added
+func rescanDirectory(path string) {
+	oldNames, _ := fileTree.GetChildren(path)
+	newNames, nameDirents := readDirectory(path)
+	created, _ := sliceDifference(newNames, oldNames)
+	changed := updateDirectory(path, newNames, nameDirents)
+	lastReload.Added += len(created)
+	lastReload.Removed += len(changed) - len(created)
+
+	for name, dirent := range nameDirents {
+		childPath := filepath.Join(path, name)
+		if dirent.IsDir() && !isCovered(childPath, changed) {
+			reconcileQueue = append(reconcileQueue, childPath)
+		}
+	}
+}
+
+// setFiltered records whether entries of the directory at path
+// were filtered when it was read
+func setFiltered(path string, filtered bool) {
+	node, err := fileTree.Find(path)
+	switch {
+	case err != nil:
+	case filtered:
+		filteredDirectories[*node] = true
+	default:
+		delete(filteredDirectories, *node)
+	}
+}
+
+// forgetFiltered removes the directory of node from filteredDirectories,
+// deleteFromIndex calls it for every directory of a removed subtree
+func forgetFiltered(node tree.Node) {
+	delete(filteredDirectories, node)
+}
@@ -0,0 +1,68 @@
+package database
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReconcileDirectory_Rescan(t *testing.T) {
+	root := tempTree(t, 2, 2)
+	defer os.RemoveAll(root)
+	defer func() { lastReload = nil }()
+	defer func() { indexingDone = false }()
+	parallelWalk(root, 4)
+
+	// dir0 was filtered before the reload, the file that was created
+	// in dir1 is picked up by the watcher and not by the rescan
+	removeFromIndex(root, "dir0")
+	setFiltered(root, true)
+	created := filepath.Join(root, "dir1", "new")
+	if err := ioutil.WriteFile(created, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lastReload = &ReloadResult{Rescan: true, InProgress: true}
+	reconcileQueue = []string{root}
+	for lastReload.InProgress {
+		if activeWalk != nil {
+			runWalk()
+		} else {
+			reconcileBatch()
+		}
+	}
+
+	paths := indexedPaths(root)
+	if !paths[filepath.Join(root, "dir0", "dir1", "file0")] {
+		t.Errorf("dir0 wasn't indexed again")
+	}
+	if paths[created] {
+		t.Errorf("dir1 was read again")
+	}
+	if lastReload.Added != 1 || lastReload.Removed != 0 {
+		t.Errorf("added %d and removed %d entries, want 1 and 0",
+			lastReload.Added, lastReload.Removed)
+	}
+	if node, _ := fileTree.Find(root); filteredDirectories[*node] {
+		t.Errorf("the root still contains filtered entries")
+	}
+}
+
+func TestRemoveFromIndex_Filtered(t *testing.T) {
+	root := tempTree(t, 2, 2)
+	defer os.RemoveAll(root)
+	defer func() { indexingDone = false }()
+	parallelWalk(root, 4)
+
+	// removing a directory forgets that it and the
+	// directories below it contained filtered entries
+	for _, dir := range []string{"dir0", "dir0/dir1", "dir1"} {
+		setFiltered(filepath.Join(root, dir), true)
+	}
+	removeFromIndex(root, "dir0")
+	node, _ := fileTree.Find(filepath.Join(root, "dir1"))
+	if len(filteredDirectories) != 1 || !filteredDirectories[*node] {
+		t.Errorf("%d directories contain filtered entries, want only dir1", len(filteredDirectories))
+	}
+}
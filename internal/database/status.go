@@ -0,0 +1,64 @@
+package database
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/request"
+)
+
+// status is the document that is sent in response to status requests
+type status struct {
+	Version string `json:"version"`
+	// Uptime is the time since the server was started in seconds
+	Uptime       float64 `json:"uptime_s"`
+	IndexingDone bool    `json:"indexing_done"`
+	Files        int64   `json:"files"`
+	Directories  int64   `json:"directories"`
+	// LastChange is the time at which the last file change
+	// was applied to the index
+	LastChange *time.Time      `json:"last_change,omitempty"`
+	Watcher    string          `json:"watcher"`
+	UserMode   bool            `json:"user_mode"`
+	Roots      []string        `json:"roots"`
+	Config     json.RawMessage `json:"config"`
+	LastReload *ReloadResult   `json:"last_reload"`
+}
+
+var startTime time.Time
+var watcherName string
+var lastChangeTime time.Time
+
+func sendStatus(req request.Request) {
+	files, directories := IndexSize()
+	s := status{
+		Version:      config.Version,
+		Uptime:       time.Since(startTime).Seconds(),
+		IndexingDone: indexingDone,
+		Files:        files,
+		Directories:  directories,
+		Watcher:      watcherName,
+		UserMode:     config.UserMode(),
+		Roots:        config.Roots(),
+		LastReload:   lastReload,
+	}
+	if !lastChangeTime.IsZero() {
+		s.LastChange = &lastChangeTime
+	}
+
+	var err error
+	s.Config, err = config.JSON()
+	if err != nil {
+		slog.Error("failed to encode config", "err", err)
+	}
+
+	statusBytes, err := json.Marshal(s)
+	if err != nil {
+		slog.Error("failed to encode status", "err", err)
+		return
+	}
+
+	req.Send(request.Response{Status: statusBytes})
+}
@@ -0,0 +1,220 @@
+package database
+
+import (
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	trie "gopkg.in/ozeidan/fuzzy-patricia.v3/patricia"
+)
+
+// trigramIndex maps the trigrams of the case and accent folded names
+// in the index to the names that contain them, it narrows down the names
+// that are compared for substring and regex searches in every mode
+type trigramIndex struct {
+	folder *folder
+	// names holds the names by their id, the names that were
+	// removed are empty until the postings are compacted
+	names []string
+	ids   map[string]uint32
+	// removed holds the ids of the removed names that are still in
+	// the postings, free those that can be given to new names
+	removed []uint32
+	free    []uint32
+	// postings holds the ids of the names containing a trigram,
+	// ids are appended and the postings in unsorted are sorted
+	// when they are used
+	postings map[uint32][]uint32
+	unsorted map[uint32]bool
+}
+
+// trigrams is nil if the trigram index is disabled
+var trigrams *trigramIndex
+
+func newTrigramIndex() *trigramIndex {
+	return &trigramIndex{
+		folder:   newFolder(true, true),
+		ids:      make(map[string]uint32),
+		postings: make(map[uint32][]uint32),
+		unsorted: make(map[uint32]bool),
+	}
+}
+
+// buildTrigramIndex creates a trigram index of the names in the index
+func buildTrigramIndex() *trigramIndex {
+	t := newTrigramIndex()
+	indexTrie.Visit(func(prefix trie.Prefix, item trie.Item) error {
+		t.add(string(prefix))
+		return nil
+	})
+	return t
+}
+
+// forEachTrigram calls f with every distinct trigram of the key of s
+func (t *trigramIndex) forEachTrigram(s string, f func(trigram uint32)) {
+	s = t.folder.fold(s)
+	seen := make(map[uint32]bool, len(s))
+	for i := 0; i+3 <= len(s); i++ {
+		trigram := uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+		if !seen[trigram] {
+			seen[trigram] = true
+			f(trigram)
+		}
+	}
+}
+
+func (t *trigramIndex) add(name string) {
+	if _, ok := t.ids[name]; ok {
+		return
+	}
+	var id uint32
+	if len(t.free) > 0 {
+		id = t.free[len(t.free)-1]
+		t.free = t.free[:len(t.free)-1]
+		t.names[id] = name
+	} else {
+		id = uint32(len(t.names))
+		t.names = append(t.names, name)
+	}
+	t.ids[name] = id
+
+	t.forEachTrigram(name, func(trigram uint32) {
+		posting := t.postings[trigram]
+		// new ids are larger than the others, except the free ones
+		if len(posting) > 0 && posting[len(posting)-1] > id {
+			t.unsorted[trigram] = true
+		}
+		t.postings[trigram] = append(posting, id)
+	})
+}
+
+// remove only marks the id of name as removed, the postings
+// are compacted once half of the ids were removed
+func (t *trigramIndex) remove(name string) {
+	id, ok := t.ids[name]
+	if !ok {
+		return
+	}
+	delete(t.ids, name)
+	t.names[id] = ""
+	t.removed = append(t.removed, id)
+
+	if 2*len(t.removed) > len(t.names) {
+		t.compact()
+	}
+}
+
+// compact removes the ids of the removed names from the postings,
+// so they can be given to new names
+func (t *trigramIndex) compact() {
+	for trigram, posting := range t.postings {
+		kept := posting[:0]
+		for _, id := range posting {
+			if t.names[id] != "" {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.postings, trigram)
+			delete(t.unsorted, trigram)
+			continue
+		}
+		t.postings[trigram] = kept
+	}
+	t.free = append(t.free, t.removed...)
+	t.removed = t.removed[:0]
+}
+
+// posting returns the sorted ids of the names containing trigram,
+// the ids of removed names may be among them
+func (t *trigramIndex) posting(trigram uint32) []uint32 {
+	posting := t.postings[trigram]
+	if t.unsorted[trigram] {
+		sort.Slice(posting, func(i, j int) bool { return posting[i] < posting[j] })
+		delete(t.unsorted, trigram)
+	}
+	return posting
+}
+
+// candidates returns the names that contain all trigrams of literals,
+// it returns false if the literals contain no trigrams,
+// so every name is a candidate
+func (t *trigramIndex) candidates(literals []string) ([]string, bool) {
+	var ids []uint32
+	found := false
+	for _, literal := range literals {
+		t.forEachTrigram(literal, func(trigram uint32) {
+			posting := t.posting(trigram)
+			if !found {
+				ids = append([]uint32(nil), posting...)
+				found = true
+				return
+			}
+			ids = intersect(ids, posting)
+		})
+	}
+	if !found {
+		return nil, false
+	}
+
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if name := t.names[id]; name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// intersect returns the ids contained in both sorted slices,
+// reusing the memory of a
+func intersect(a, b []uint32) []uint32 {
+	result := a[:0]
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// requiredLiterals returns strings that every match of re contains
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// adjacent literals are matched as one string
+		var literals []string
+		var run strings.Builder
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				run.WriteString(string(sub.Rune))
+				continue
+			}
+			if run.Len() > 0 {
+				literals = append(literals, run.String())
+				run.Reset()
+			}
+			literals = append(literals, requiredLiterals(sub)...)
+		}
+		if run.Len() > 0 {
+			literals = append(literals, run.String())
+		}
+		return literals
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"regexp/syntax"
+	"testing"
+
+	"github.com/ozeidan/gosearch/internal/request"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"README", []string{"README"}},
+		{`^index\.js$`, []string{"index.js"}},
+		{`test_.*\.go`, []string{"test_", ".go"}},
+		{"(foo|bar)baz", []string{"baz"}},
+		{"(abc)+x?yz", []string{"abc", "yz"}},
+		{"(ab){2,}", []string{"ab", "ab"}},
+		{"[ab]cd*", []string{"c"}},
+		{".*", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			re, err := syntax.Parse(tt.expr, syntax.Perl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := requiredLiterals(re.Simplify()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredLiterals() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// querySettingsWith returns the sorted results of a query
+// with and without the trigram index
+func querySettingsWith(q string, settings request.Settings) (withIndex, without []string) {
+	trigrams = buildTrigramIndex()
+	withIndex = sortedQuery(q, settings)
+	trigrams = nil
+	return withIndex, sortedQuery(q, settings)
+}
+
+// queryWith is querySettingsWith for queries that only set the action
+// and the case sensitivity
+func queryWith(action int, q string, caseInsensitive bool) (withIndex, without []string) {
+	return querySettingsWith(q, request.Settings{
+		Action:          action,
+		CaseInsensitive: caseInsensitive,
+	})
+}
+
+func TestQueryIndex_Trigram(t *testing.T) {
+	defer resetWalkedIndex()()
+	indexProjects("/root", 50)
+	// the index is updated when names are removed
+	trigrams = newTrigramIndex()
+	removeFromIndex("/root", "project7")
+	trigrams = nil
+
+	tests := []struct {
+		action          int
+		query           string
+		caseInsensitive bool
+		wantCount       int
+	}{
+		{request.SubStringSearch, "ject1", false, 11},
+		{request.SubStringSearch, "PROJECT", false, 0},
+		{request.SubStringSearch, "PROJECT", true, 49},
+		{request.SubStringSearch, "ex", false, 49},
+		{request.SubStringSearch, "init", false, 49},
+		{request.RegexSearch, `^file\d$`, false, 9},
+		{request.RegexSearch, `^FILE[0-9]+$`, true, 49},
+		{request.RegexSearch, `project(1|2)\d`, false, 20},
+		{request.RegexSearch, `.`, false, 49 * 5},
+		// plain text clients don't get the notice
+		{request.RegexSearch, `(`, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s_%s", request.ActionName(tt.action), tt.query), func(t *testing.T) {
+			withIndex, without := queryWith(tt.action, tt.query, tt.caseInsensitive)
+			if !reflect.DeepEqual(withIndex, without) {
+				t.Errorf("results with the trigram index %v, without %v", withIndex, without)
+			}
+			if len(withIndex) != tt.wantCount {
+				t.Errorf("got %d results, want %d", len(withIndex), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestTrigramIndex_Remove(t *testing.T) {
+	index := newTrigramIndex()
+	for i := 0; i < 10; i++ {
+		index.add(fmt.Sprintf("name%d", i))
+	}
+	candidates := func() []string {
+		names, _ := index.candidates([]string{"name"})
+		return names
+	}
+
+	// removed names are skipped until the postings are compacted,
+	// their ids are then given to new names
+	index.remove("name1")
+	nam := uint32('n')<<16 | uint32('a')<<8 | uint32('m')
+	if got := candidates(); len(got) != 9 || len(index.postings[nam]) != 10 {
+		t.Errorf("candidates are %v with %d ids in the posting", got, len(index.postings[nam]))
+	}
+	for i := 2; i < 7; i++ {
+		index.remove(fmt.Sprintf("name%d", i))
+	}
+	if len(index.removed) != 0 || len(index.free) != 6 {
+		t.Errorf("%d ids are removed and %d free after compacting", len(index.removed), len(index.free))
+	}
+	index.add("name10")
+	index.add("name11")
+	want := []string{"name0", "name11", "name10", "name7", "name8", "name9"}
+	if got := candidates(); !reflect.DeepEqual(got, want) {
+		t.Errorf("candidates are %v, want %v", got, want)
+	}
+}
+
+// BenchmarkSubstringSearch compares substring searches
+// with and without the trigram index
+func BenchmarkSubstringSearch(b *testing.B) {
+	defer resetWalkedIndex()()
+	indexProjects("/root", 50000)
+
+	for _, enabled := range []bool{false, true} {
+		b.Run(fmt.Sprintf("trigram_index_%v", enabled), func(b *testing.B) {
+			trigrams = nil
+			if enabled {
+				trigrams = buildTrigramIndex()
+			}
+			defer func() { trigrams = nil }()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				query("ject4242", request.Settings{Action: request.SubStringSearch, MaxResults: 100})
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package database
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/karrick/godirwalk"
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/ignore"
+	"github.com/ozeidan/gosearch/pkg/tree"
+	trie "gopkg.in/ozeidan/fuzzy-patricia.v3/patricia"
+)
+
+// walkEntry is a directory that the walk still has to read
+type walkEntry struct {
+	path   string
+	filter config.Filter
+	// ignores are the rules of the ignore files above the directory
+	ignores *ignoreChain
+}
+
+// ignoreChain links the rules of the ignore files of a directory to
+// those of its parent directories, it is never modified, so the
+// workers of the walk can share it
+type ignoreChain struct {
+	rules  *ignore.Rules
+	parent *ignoreChain
+}
+
+// isIgnored returns whether the entry at path is excluded by the
+// rules in the chain, rules of deeper directories take precedence,
+// the parent directories of path aren't matched, readWalkEntry
+// checks the directory it reads once for all of its entries
+func (c *ignoreChain) isIgnored(path string, isDir bool) bool {
+	for ; c != nil; c = c.parent {
+		if matched, ignored := c.rules.Match(path, isDir); matched {
+			return ignored
+		}
+	}
+	return false
+}
+
+// walkedEntry is an unfiltered entry of a directory read by the walk
+type walkedEntry struct {
+	name   string
+	isDir  bool
+	filter config.Filter
+}
+
+// walkResult holds the contents of a directory read by a worker
+type walkResult struct {
+	entry   walkEntry
+	entries []walkedEntry
+	// rules holds the rules of the ignore files in the directory
+	// and modTimes the modification times of the files
+	rules    *ignore.Rules
+	modTimes []time.Time
+	// ignores is the chain that applies to the subdirectories
+	ignores *ignoreChain
+	// filtered is set when entries of the directory were filtered
+	filtered bool
+}
+
+// walker reads the directories of a walk concurrently,
+// the results are added to the index by the database goroutine
+type walker struct {
+	jobs    chan walkEntry
+	results chan walkResult
+	quit    chan struct{}
+	// queue holds the directories that weren't handed to a worker yet
+	queue []walkEntry
+	// inFlight is the number of directories handed to the workers
+	// whose results weren't added yet
+	inFlight int
+}
+
+func newWalker(parallelism int, useIgnoreFiles bool) *walker {
+	w := &walker{
+		jobs:    make(chan walkEntry),
+		results: make(chan walkResult, parallelism),
+		quit:    make(chan struct{}),
+	}
+	for i := 0; i < parallelism; i++ {
+		go w.work(useIgnoreFiles)
+	}
+	return w
+}
+
+func (w *walker) work(useIgnoreFiles bool) {
+	scratch := make([]byte, godirwalk.DefaultScratchBufferSize)
+	for {
+		select {
+		case entry := <-w.jobs:
+			select {
+			case w.results <- readWalkEntry(entry, useIgnoreFiles, scratch):
+			case <-w.quit:
+				return
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// readWalkEntry reads the directory of entry and filters its contents,
+// it must not access the index as it runs on the workers
+func readWalkEntry(entry walkEntry, useIgnoreFiles bool, scratch []byte) walkResult {
+	result := walkResult{entry: entry, ignores: entry.ignores}
+	if useIgnoreFiles {
+		result.modTimes = ignoreFileTimes(entry.path)
+	}
+	if result.modTimes != nil {
+		rules, err := ignore.ParseFiles(entry.path)
+		if err != nil {
+			slog.Warn("couldn't parse the ignore files", "path", entry.path, "err", err)
+		}
+		if rules != nil {
+			result.rules = rules
+			result.ignores = &ignoreChain{rules, entry.ignores}
+		}
+	}
+
+	dirents, err := godirwalk.ReadDirents(entry.path, scratch)
+	if err != nil {
+		slog.Debug("couldn't read directory", "path", entry.path, "err", err)
+		return result
+	}
+
+	// like in git, the entries below an ignored directory are ignored
+	// as well, the walk only reads such a directory if the configuration
+	// includes it, the directories above it were checked by the walk
+	dirIgnored := entry.ignores.isIgnored(entry.path, true)
+
+	result.entries = make([]walkedEntry, 0, len(dirents))
+	for _, de := range dirents {
+		path := filepath.Join(entry.path, de.Name())
+		filter, filtered := entry.filter.Child(path, de.IsDir())
+		if filtered || (!filter.Included() && (dirIgnored || result.ignores.isIgnored(path, de.IsDir()))) {
+			result.filtered = true
+			continue
+		}
+		result.entries = append(result.entries, walkedEntry{de.Name(), de.IsDir(), filter})
+	}
+	return result
+}
+
+// next returns the channel to send the next job on and the job,
+// the channel is nil if there is no job
+func (w *walker) next() (chan<- walkEntry, walkEntry) {
+	if len(w.queue) == 0 {
+		return nil, walkEntry{}
+	}
+	return w.jobs, w.queue[len(w.queue)-1]
+}
+
+// sent removes the job that was handed to a worker from the queue
+func (w *walker) sent() {
+	w.queue = w.queue[:len(w.queue)-1]
+	w.inFlight++
+}
+
+// pendingResults returns the channel of the results, which is nil
+// if all results were received
+func (w *walker) pendingResults() <-chan walkResult {
+	if w.inFlight == 0 {
+		return nil
+	}
+	return w.results
+}
+
+func (w *walker) done() bool {
+	return len(w.queue) == 0 && w.inFlight == 0
+}
+
+func (w *walker) stop() {
+	close(w.quit)
+}
+
+// activeWalk is the running walk, it is nil when no directories
+// are left to read, after the initial walk it reads the directories
+// that are added by reloads and file changes
+var activeWalk *walker
+
+// pendingDirectories holds the directories the walk still has
+// to read, a directory is marked as changed if a change was reported
+// for it while it was pending, as the walk may have read it before
+var pendingDirectories map[string]bool
+
+// indexingDone is set when the initial walk is finished
+var indexingDone bool
+
+var walkStart time.Time
+var walkFiles, walkDirectories uint64
+
+// startInitialIndex creates an empty index and starts walking
+// the roots, the directories are added to the index as they are
+// read, so queries are answered using the partially built index
+func startInitialIndex() {
+	indexTrie = trie.NewTrie()
+	fileTree = tree.New()
+	nonASCIINames = make(map[string]bool)
+	foldedTrie = nil
+	if config.CaseInsensitiveIndex() {
+		foldedTrie = trie.NewTrie()
+	}
+	trigrams = nil
+	if config.TrigramIndex() {
+		trigrams = newTrigramIndex()
+	}
+	// nested roots are indexed while walking their outer root
+	startWalk(config.RemoveNested(config.Roots()), config.WalkParallelism())
+}
+
+// startWalk starts reading the directories below roots
+// with parallelism workers
+func startWalk(roots []string, parallelism int) {
+	pendingDirectories = make(map[string]bool)
+	indexingDone = false
+	walkFiles, walkDirectories = 0, 0
+
+	slog.Info("starting to create initial index", "parallelism", parallelism)
+	walkStart = time.Now()
+	activeWalk = newWalker(parallelism, config.UseIgnoreFiles())
+
+	for _, root := range roots {
+		filter, filtered := config.PathFilter(root, true)
+		if filtered {
+			continue
+		}
+		addWalkedEntry(root, walkedEntry{filepath.Base(root), true, filter}, nil)
+	}
+
+	if activeWalk.done() {
+		finishWalk()
+	}
+}
+
+// walkDirectory adds the directory at path to the index and queues it
+// on the walk, which is started if it isn't running, so that large
+// directories don't keep the database from answering requests
+func walkDirectory(path string) {
+	if activeWalk == nil {
+		activeWalk = newWalker(config.WalkParallelism(), config.UseIgnoreFiles())
+		pendingDirectories = make(map[string]bool)
+	}
+	filter, _ := config.PathFilter(path, true)
+	ignores, _ := loadedIgnores(filepath.Dir(path))
+	addWalkedEntry(path, walkedEntry{filepath.Base(path), true, filter}, ignores)
+}
+
+// addWalkResult adds the contents of a directory read by the walk
+// to the index and queues its subdirectories
+func addWalkResult(result walkResult) {
+	activeWalk.inFlight--
+	path := result.entry.path
+	changed, pending := pendingDirectories[path]
+	// directories that were removed in the meantime aren't pending anymore
+	if pending {
+		delete(pendingDirectories, path)
+		if result.rules != nil || result.filtered {
+			node, _ := fileTree.Find(path)
+			if result.rules != nil {
+				ignoreRules[*node] = ignoreFiles{result.rules, result.modTimes}
+			}
+			if result.filtered {
+				filteredDirectories[*node] = true
+			}
+		}
+		for _, entry := range result.entries {
+			addWalkedEntry(filepath.Join(path, entry.name), entry, result.ignores)
+		}
+		if changed {
+			refreshDirectory(path)
+		}
+	}
+
+	if activeWalk.done() {
+		finishWalk()
+	}
+}
+
+func addWalkedEntry(path string, entry walkedEntry, ignores *ignoreChain) {
+	newNode := fileTree.Add(path)
+	indexTrieAdd(entry.name, indexedFile{*newNode, entry.isDir})
+
+	if !entry.isDir {
+		walkFiles++
+		return
+	}
+	walkDirectories++
+	activeWalk.queue = append(activeWalk.queue, walkEntry{path, entry.filter, ignores})
+	pendingDirectories[path] = false
+}
+
+// finishWalk stops the walk once all of its directories were added
+func finishWalk() {
+	activeWalk.stop()
+	activeWalk = nil
+	pendingDirectories = nil
+	if !indexingDone {
+		finishInitialIndex()
+	}
+}
+
+func finishInitialIndex() {
+	indexingDone = true
+	duration := time.Since(walkStart)
+	initialIndexDuration.Set(duration.Seconds())
+
+	slog.Info("finished creating initial index", "files", walkFiles,
+		"directories", walkDirectories, "duration", duration)
+	PrintMemUsage()
+
+	select {
+	case <-ready:
+	default:
+		close(ready)
+	}
+}
+
+// isWalked returns false for the directories that the walk
+// still has to read, their changes are picked up by the walk itself
+func isWalked(path string) bool {
+	if _, pending := pendingDirectories[path]; pending {
+		pendingDirectories[path] = true
+		return false
+	}
+	return true
+}
+
+// forgetPending removes the directory at path and the directories below
+// it from the walk, after they were removed from the index
+func forgetPending(path string) {
+	for pending := range pendingDirectories {
+		if pending == path || strings.HasPrefix(pending, path+"/") {
+			delete(pendingDirectories, pending)
+		}
+	}
+}
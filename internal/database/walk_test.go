@@ -0,0 +1,262 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karrick/godirwalk"
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/ignore"
+	"github.com/ozeidan/gosearch/pkg/tree"
+	trie "gopkg.in/ozeidan/fuzzy-patricia.v3/patricia"
+)
+
+// createTree creates a directory tree of the given depth below dir,
+// every directory contains fanout directories and files
+func createTree(tb testing.TB, dir string, depth, fanout int) {
+	for i := 0; i < fanout; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if depth == 0 {
+		return
+	}
+	for i := 0; i < fanout; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("dir%d", i))
+		if err := os.Mkdir(path, 0700); err != nil {
+			tb.Fatal(err)
+		}
+		createTree(tb, path, depth-1, fanout)
+	}
+}
+
+// treePaths returns the paths of root and the entries
+// that createTree creates below it
+func treePaths(root string, depth, fanout int) map[string]bool {
+	paths := map[string]bool{root: true}
+	for i := 0; i < fanout; i++ {
+		paths[filepath.Join(root, fmt.Sprintf("file%d", i))] = true
+		if depth > 0 {
+			for path := range treePaths(filepath.Join(root, fmt.Sprintf("dir%d", i)), depth-1, fanout) {
+				paths[path] = true
+			}
+		}
+	}
+	return paths
+}
+
+func tempTree(tb testing.TB, depth, fanout int) string {
+	dir, err := ioutil.TempDir("", "gosearch-walk")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	createTree(tb, dir, depth, fanout)
+	return dir
+}
+
+func resetIndex() {
+	indexTrie = trie.NewTrie()
+	fileTree = tree.New()
+	nonASCIINames = make(map[string]bool)
+	foldedTrie = nil
+	trigrams = nil
+	paths = nil
+	ignoreRules = make(map[tree.Node]ignoreFiles)
+	filteredDirectories = make(map[tree.Node]bool)
+}
+
+// resetWalkedIndex resets the index as if the initial walk was done,
+// the returned function has to be deferred to undo that
+func resetWalkedIndex() func() {
+	resetIndex()
+	indexingDone = true
+	return func() { indexingDone = false }
+}
+
+// parallelWalk indexes root with the parallel walker,
+// like the database goroutine does during the initial walk
+func parallelWalk(root string, parallelism int) {
+	resetIndex()
+	startWalk([]string{root}, parallelism)
+	runWalk()
+}
+
+// runWalk hands the directories of the active walk to its workers
+// and adds their results until the walk is finished
+func runWalk() {
+	for activeWalk != nil {
+		jobs, next := activeWalk.next()
+		select {
+		case jobs <- next:
+			activeWalk.sent()
+		case result := <-activeWalk.pendingResults():
+			addWalkResult(result)
+		}
+	}
+}
+
+// errFilter skips the filtered entries of sequentialWalk
+var errFilter = errors.New("directory filtered")
+
+// dirFilter is the filter state of a directory during sequentialWalk
+type dirFilter struct {
+	path    string
+	filter  config.Filter
+	ignores *ignoreChain
+}
+
+// sequentialWalk indexes root by walking it in a single goroutine,
+// as the database did before the parallel walker, BenchmarkInitialWalk
+// compares the walker with it
+func sequentialWalk(root string) {
+	resetIndex()
+	// the filter states of the directories leading to the current entry,
+	// entries are matched by extending the state of their parent
+	var dirFilters []dirFilter
+
+	godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			var filter config.Filter
+			var filtered bool
+			var ignores *ignoreChain
+			parent := filepath.Dir(osPathname)
+			for len(dirFilters) > 0 && dirFilters[len(dirFilters)-1].path != parent {
+				dirFilters = dirFilters[:len(dirFilters)-1]
+			}
+			if len(dirFilters) == 0 {
+				filter, filtered = config.PathFilter(osPathname, de.IsDir())
+			} else {
+				filter, filtered = dirFilters[len(dirFilters)-1].filter.Child(osPathname, de.IsDir())
+				ignores = dirFilters[len(dirFilters)-1].ignores
+			}
+
+			if filtered || (!filter.Included() && ignores.isIgnored(osPathname, de.IsDir())) {
+				if osPathname != root {
+					setFiltered(parent, true)
+				}
+				return errFilter
+			}
+
+			if de.IsDir() {
+				// the ignore files apply to the entries below,
+				// which are visited after the directory itself
+				if config.UseIgnoreFiles() {
+					if rules, _ := ignore.ParseFiles(osPathname); rules != nil {
+						ignores = &ignoreChain{rules, ignores}
+					}
+				}
+				dirFilters = append(dirFilters, dirFilter{osPathname, filter, ignores})
+			}
+
+			newNode := fileTree.Add(osPathname)
+			indexTrieAdd(de.Name(), indexedFile{*newNode, de.IsDir()})
+			return nil
+		},
+		Unsorted: true,
+		ErrorCallback: func(_ string, err error) godirwalk.ErrorAction {
+			return godirwalk.SkipNode
+		},
+	})
+}
+
+// indexedPaths returns the paths of all indexed entries below root
+func indexedPaths(root string) map[string]bool {
+	paths := make(map[string]bool)
+	var collect func(path string)
+	collect = func(path string) {
+		paths[path] = true
+		children, err := fileTree.GetChildren(path)
+		if err != nil {
+			return
+		}
+		for _, child := range children {
+			collect(filepath.Join(path, child))
+		}
+	}
+	collect(root)
+	return paths
+}
+
+func TestParallelWalk(t *testing.T) {
+	root := tempTree(t, 3, 4)
+	defer os.RemoveAll(root)
+	defer func() { indexingDone = false }()
+	want := treePaths(root, 3, 4)
+
+	for _, parallelism := range []int{1, 4} {
+		t.Run(fmt.Sprintf("parallelism_%d", parallelism), func(t *testing.T) {
+			parallelWalk(root, parallelism)
+			got := indexedPaths(root)
+			if len(got) != len(want) {
+				t.Errorf("indexed %d entries, want %d", len(got), len(want))
+			}
+			for path := range want {
+				if !got[path] {
+					t.Errorf("%s wasn't indexed", path)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInitialWalk(b *testing.B) {
+	root := tempTree(b, 4, 8)
+	defer os.RemoveAll(root)
+	defer func() { indexingDone = false }()
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sequentialWalk(root)
+		}
+	})
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallel_%d", parallelism), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				parallelWalk(root, parallelism)
+			}
+		})
+	}
+}
+
+func TestReadWalkEntry_IgnoredDirectory(t *testing.T) {
+	root := tempTree(t, 2, 2)
+	defer os.RemoveAll(root)
+	ignoreFile := filepath.Join(root, ".gitignore")
+	if err := ioutil.WriteFile(ignoreFile, []byte("dir0/\n!file0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	names := func(result walkResult) map[string]bool {
+		names := make(map[string]bool)
+		for _, entry := range result.entries {
+			names[entry.name] = true
+		}
+		return names
+	}
+
+	filter, _ := config.PathFilter(root, true)
+	result := readWalkEntry(walkEntry{root, filter, nil}, true, nil)
+	got := names(result)
+	if got["dir0"] || !got["dir1"] || !got["file0"] {
+		t.Errorf("the entries of the root are %v", got)
+	}
+
+	// the walk only reads an ignored directory if the configuration
+	// includes it, the entries below it stay ignored
+	dir := filepath.Join(root, "dir0")
+	filter, _ = filter.Child(dir, true)
+	result = readWalkEntry(walkEntry{dir, filter, result.ignores}, true, nil)
+	if got := names(result); len(got) != 0 {
+		t.Errorf("the entries of the ignored directory %v weren't ignored", got)
+	}
+}
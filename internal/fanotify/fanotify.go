@@ -4,13 +4,17 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
 
 	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/metrics"
+	"github.com/ozeidan/gosearch/internal/watcher"
+	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
 )
 
@@ -25,6 +29,7 @@ const (
 	fanDelete         = 0x00000200 /* Subfile was deleted */
 	fanDeleteSelf     = 0x00000400 /* Self was deleted */
 	fanMoveSelf       = 0x00000800 /* Self was moved */
+	fanQOverflow      = 0x00004000 /* Event queue overflowed */
 	fanEventOnChild   = 0x08000000 /* interested in child events */
 	atFDCWD           = -100
 )
@@ -53,78 +58,121 @@ type fanotifyEventInfoFid struct {
 	eventFid fanotifyEventFid
 }
 
-// FileChange describes the event of changes in a directory
-// FolderPath is the path of the directory
-// Changetype is either Creation or Deletion
-type FileChange struct {
-	FolderPath string
-	ChangeType int
+// Watcher listens for created/deleted/moved files
+// on the file systems of its roots using fanotify
+type Watcher struct {
+	file      *os.File
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-const (
-	// Creation of a file/directory
-	Creation = iota
-	// Deletion of a file/directory
-	Deletion
-)
-
-// Listen starts listening for created/deleted/moved
-// files in the whole file system
-// changeReceiver is a channel that FileChange structs,
-// which describe the events, will be sent through
-func Listen(changeReceiver chan<- FileChange) {
-	log.Println("starting to listen on fanotify events")
-	fan, err := unix.FanotifyInit(fanReportFid, 0)
+// New initializes fanotify and marks the file systems containing
+// roots, it fails on kernels without support for FAN_REPORT_FID
+func New(roots []string) (*Watcher, error) {
+	fan, err := unix.FanotifyInit(fanReportFid|unix.FAN_NONBLOCK|unix.FAN_CLOEXEC, 0)
 	if err != nil {
-		fmt.Println(err)
-		panic("could not call fanotifyinit")
+		return nil, errors.Wrap(err, "could not call fanotifyinit")
 	}
 
-	err = unix.FanotifyMark(fan, markFlags, markMask, atFDCWD, "/")
-
-	if err != nil {
-		fmt.Println(err)
-		panic("could not call fanotifymark")
+	for _, root := range roots {
+		err = unix.FanotifyMark(fan, markFlags, markMask, atFDCWD, root)
+		if err != nil {
+			unix.Close(fan)
+			return nil, errors.Wrapf(err, "could not call fanotifymark on %s", root)
+		}
 	}
 
-	log.Println("fanotify initialized")
+	slog.Info("fanotify initialized")
+
+	return &Watcher{
+		file: os.NewFile(uintptr(fan), "fanotify"),
+		done: make(chan struct{}),
+	}, nil
+}
 
-	f := os.NewFile(uintptr(fan), "")
-	r := bufio.NewReader(f)
+// Listen starts listening for created/deleted/moved
+// files in the marked file systems
+// changeReceiver is a channel that FileChange structs,
+// which describe the events, will be sent through
+func (w *Watcher) Listen(changeReceiver chan<- watcher.FileChange) {
+	slog.Info("starting to listen on fanotify events")
+	r := bufio.NewReader(w.file)
 
+	var backoff watcher.Backoff
 	for {
-		readEvent(r, changeReceiver)
+		err := readEvent(r, changeReceiver, w.done)
+		if err == nil {
+			backoff.Reset()
+			continue
+		}
+
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		slog.Error("failed to read fanotify event", "err", err, "retry_in", backoff.Next())
+		if !backoff.Wait(w.done) {
+			return
+		}
 	}
 }
 
+// Close stops listening for events
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+// Name returns the name of the backend
+func (w *Watcher) Name() string {
+	return "fanotify"
+}
+
 var metaBuff = make([]byte, 24)
 
-func readEvent(r io.Reader, changeReceiver chan<- FileChange) {
+// readEvent reads an event from r and sends its change through
+// changeReceiver, unless done is closed
+func readEvent(r io.Reader, changeReceiver chan<- watcher.FileChange, done <-chan struct{}) error {
 	n, err := r.Read(metaBuff)
 	if err != nil {
-		return
+		return err
 	}
 
 	if n < 0 || n > 24 {
-		return
+		return nil
 	}
 
 	meta := *((*unix.FanotifyEventMetadata)(unsafe.Pointer(&metaBuff[0])))
+	events.With(eventType(meta.Mask)).Inc()
+	if meta.Mask&fanQOverflow > 0 {
+		slog.Warn("fanotify queue overflowed, changes were lost")
+		watcher.Overflows.With("fanotify").Inc()
+		return nil
+	}
+
 	bytesLeft := int(meta.Event_len - uint32(meta.Metadata_len))
+	if bytesLeft <= 0 {
+		return nil
+	}
 	infoBuff := make([]byte, bytesLeft)
 	n, err = r.Read(infoBuff)
 	if err != nil {
-		return
+		return err
 	}
 
 	if n < 0 || n > bytesLeft {
-		return
+		return nil
 	}
 
 	info := *((*fanotifyEventInfoFid)(unsafe.Pointer(&infoBuff[0])))
 
 	if info.hdr.infoType != 1 { // TODO: properly define constant
-		return
+		return nil
 	}
 
 	handleStart := uint32(unsafe.Sizeof(info))
@@ -134,14 +182,14 @@ func readEvent(r io.Reader, changeReceiver chan<- FileChange) {
 
 	fd, err := unix.OpenByHandleAt(atFDCWD, unixFileHandle, 0)
 	if err != nil {
-		log.Println("could not call OpenByHandleAt:", err)
-		return
+		slog.Debug("could not call OpenByHandleAt", "err", err)
+		return nil
 	}
 
 	defer func() {
 		err = syscall.Close(fd)
 		if err != nil {
-			log.Println("warning: couldn't close file descriptor", err)
+			slog.Warn("couldn't close file descriptor", "err", err)
 		}
 	}()
 
@@ -150,32 +198,55 @@ func readEvent(r io.Reader, changeReceiver chan<- FileChange) {
 	pathLength, err := unix.Readlink(sym, path)
 
 	if err != nil {
-		log.Println("could not call Readlink:", err)
-		return
+		slog.Debug("could not call Readlink", "err", err)
+		return nil
 	}
 	path = path[:pathLength]
 	if config.IsPathFiltered(string(path)) {
-		return
+		return nil
 	}
-	log.Println("received event, path:", string(path),
-		"flags:", maskToString(meta.Mask))
+	slog.Debug("received event", "path", string(path),
+		"flags", maskToString(meta.Mask))
 
 	changeType := 0
 	if meta.Mask&unix.IN_CREATE > 0 ||
 		meta.Mask&unix.IN_MOVED_TO > 0 {
-		changeType = Creation
+		changeType = watcher.Creation
 	}
 	if meta.Mask&unix.IN_DELETE > 0 ||
 		meta.Mask&unix.IN_MOVED_FROM > 0 {
-		changeType = Deletion
+		changeType = watcher.Deletion
 	}
 
-	change := FileChange{
-		string(path),
-		changeType,
+	change := watcher.FileChange{
+		FolderPath: string(path),
+		ChangeType: changeType,
 	}
 
-	changeReceiver <- change
+	select {
+	case changeReceiver <- change:
+	case <-done:
+	}
+	return nil
+}
+
+var events = metrics.NewCounterVec("gosearch_fanotify_events_total",
+	"Number of received fanotify events by type.", "type")
+
+func eventType(mask uint64) string {
+	switch {
+	case mask&fanQOverflow > 0:
+		return "overflow"
+	case mask&fanCreate > 0:
+		return "create"
+	case mask&fanDelete > 0:
+		return "delete"
+	case mask&fanMovedFrom > 0:
+		return "moved_from"
+	case mask&fanMovedTo > 0:
+		return "moved_to"
+	}
+	return "other"
 }
 
 func maskToString(mask uint64) string {
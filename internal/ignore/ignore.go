@@ -0,0 +1,300 @@
+package ignore
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileNames are the names of the ignore files that are read
+// from the indexed directories
+var FileNames = []string{".gitignore", ".ignore"}
+
+// pattern is a single compiled gitignore pattern
+type pattern struct {
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	// segments holds the components of anchored patterns,
+	// it is nil for patterns that match at any depth
+	segments []string
+}
+
+// Rules is an ordered list of gitignore patterns,
+// which are relative to a base directory
+type Rules struct {
+	base     string
+	patterns []pattern
+}
+
+// Parse compiles the gitignore patterns in lines,
+// anchored patterns are relative to base
+func Parse(base string, lines []string) (*Rules, error) {
+	rules := &Rules{base: base}
+	for _, line := range lines {
+		p, ok, err := compile(line)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules.patterns = append(rules.patterns, p)
+		}
+	}
+	return rules, nil
+}
+
+// ParseFiles reads the ignore files in dir, it returns nil
+// if the directory doesn't contain any ignore files
+func ParseFiles(dir string) (*Rules, error) {
+	var lines []string
+	found := false
+
+	for _, name := range FileNames {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		found = true
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return Parse(dir, lines)
+}
+
+// Check returns an error if line isn't a valid pattern
+func Check(line string) error {
+	_, _, err := compile(line)
+	return err
+}
+
+// Match returns whether one of the patterns matches path and if so,
+// whether path is ignored or re-included by a negated pattern
+// the last matching pattern decides
+func (r *Rules) Match(path string, isDir bool) (matched, ignored bool) {
+	if r == nil || len(r.patterns) == 0 {
+		return false, false
+	}
+
+	relative, ok := r.relative(path)
+	if !ok || relative == "" {
+		// path is not inside of base
+		return false, false
+	}
+
+	for i := len(r.patterns) - 1; i >= 0; i-- {
+		p := r.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.regex.MatchString(relative) {
+			return true, !p.negate
+		}
+	}
+	return false, false
+}
+
+// IgnoresParent returns whether one of the parent directories of path
+// inside of the base is ignored, entries below an ignored directory
+// are ignored as well and can't be re-included, like in git
+func (r *Rules) IgnoresParent(path string) bool {
+	if r == nil || len(r.patterns) == 0 {
+		return false
+	}
+	relative, ok := r.relative(path)
+	if !ok {
+		return false
+	}
+
+	// the parents are matched from the base downwards
+	start := len(path) - len(relative)
+	for i := 0; i < len(relative); i++ {
+		if relative[i] != '/' {
+			continue
+		}
+		if _, ignored := r.Match(path[:start+i], true); ignored {
+			return true
+		}
+	}
+	return false
+}
+
+// CouldMatchBelow returns whether a pattern that isn't negated
+// may match an entry below the directory dir
+func (r *Rules) CouldMatchBelow(dir string) bool {
+	if r == nil {
+		return false
+	}
+	relative, ok := r.relative(dir)
+	if !ok {
+		return false
+	}
+	var parts []string
+	if relative != "" {
+		parts = strings.Split(relative, "/")
+	}
+
+	for _, p := range r.patterns {
+		if p.negate {
+			continue
+		}
+		if p.segments == nil || segmentsCouldMatch(p.segments, parts) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentsCouldMatch returns whether the pattern segments may
+// match an entry below the directory with the components parts
+func segmentsCouldMatch(segments, parts []string) bool {
+	for i, part := range parts {
+		if i >= len(segments) {
+			return false
+		}
+		if segments[i] == "**" {
+			return true
+		}
+		glob := strings.Replace(segments[i], "[!", "[^", -1)
+		if ok, _ := path.Match(glob, part); !ok {
+			return false
+		}
+	}
+	return len(segments) > len(parts)
+}
+
+// relative returns path relative to the base directory
+// and whether path is inside of it
+func (r *Rules) relative(path string) (string, bool) {
+	if path == r.base {
+		return "", true
+	}
+	relative := strings.TrimPrefix(path, strings.TrimSuffix(r.base, "/")+"/")
+	return relative, len(relative) != len(path)
+}
+
+// Equal returns whether r and other consist of the same patterns
+func (r *Rules) Equal(other *Rules) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	if r.base != other.base || len(r.patterns) != len(other.patterns) {
+		return false
+	}
+	for i, p := range r.patterns {
+		o := other.patterns[i]
+		if p.regex.String() != o.regex.String() ||
+			p.negate != o.negate || p.dirOnly != o.dirOnly {
+			return false
+		}
+	}
+	return true
+}
+
+// compile translates a line of a gitignore file into a pattern,
+// ok is false for blank lines and comments
+func compile(line string) (p pattern, ok bool, err error) {
+	line = trimTrailingSpaces(line)
+	if line == "" || line[0] == '#' {
+		return p, false, nil
+	}
+
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if line[0] == '\\' && len(line) > 1 && (line[1] == '!' || line[1] == '#') {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false, nil
+	}
+
+	// patterns containing a slash are relative to the base directory,
+	// others match the name at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expression, err := globToRegex(line)
+	if err != nil {
+		return p, false, err
+	}
+	if anchored {
+		p.segments = strings.Split(line, "/")
+	} else {
+		expression = "(?:.*/)?" + expression
+	}
+
+	p.regex, err = regexp.Compile("^" + expression + "$")
+	if err != nil {
+		return p, false, errors.Wrapf(err, "invalid pattern %q", line)
+	}
+	return p, true, nil
+}
+
+func globToRegex(glob string) (string, error) {
+	var builder strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// zero or more directories
+			builder.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			// everything inside of a directory
+			builder.WriteString("/.+")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case c == '*':
+			builder.WriteString("[^/]*")
+		case c == '?':
+			builder.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", errors.Errorf("unterminated character class in pattern %q", glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			builder.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return builder.String(), nil
+}
+
+// trimTrailingSpaces removes trailing spaces that aren't escaped
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
@@ -0,0 +1,142 @@
+package ignore
+
+import "testing"
+
+func TestRules_Match(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		matched  bool
+		ignored  bool
+	}{
+		{"floating_name", []string{"*.o"}, "/src/a/b/main.o", false, true, true},
+		{"floating_no_match", []string{"*.o"}, "/src/a/main.go", false, false, false},
+		{"star_within_component", []string{"a*b"}, "/src/a/x/b", false, false, false},
+		{"anchored", []string{"/build"}, "/src/build", true, true, true},
+		{"anchored_not_deeper", []string{"/build"}, "/src/a/build", true, false, false},
+		{"anchored_middle_slash", []string{"doc/*.txt"}, "/src/doc/a.txt", false, true, true},
+		{"anchored_middle_slash_deeper", []string{"doc/*.txt"}, "/src/a/doc/a.txt", false, false, false},
+		{"directory_only_dir", []string{"cache/"}, "/src/x/cache", true, true, true},
+		{"directory_only_file", []string{"cache/"}, "/src/x/cache", false, false, false},
+		{"leading_double_star", []string{"**/logs"}, "/src/a/b/logs", true, true, true},
+		{"leading_double_star_top", []string{"**/logs"}, "/src/logs", true, true, true},
+		{"trailing_double_star", []string{"out/**"}, "/src/out/a/b", false, true, true},
+		{"trailing_double_star_self", []string{"out/**"}, "/src/out", true, false, false},
+		{"middle_double_star", []string{"a/**/b"}, "/src/a/b", false, true, true},
+		{"middle_double_star_deep", []string{"a/**/b"}, "/src/a/x/y/b", false, true, true},
+		{"negation", []string{"*.log", "!keep.log"}, "/src/keep.log", false, true, false},
+		{"last_match_wins", []string{"!keep.log", "*.log"}, "/src/keep.log", false, true, true},
+		{"question_mark", []string{"file?.txt"}, "/src/file1.txt", false, true, true},
+		{"character_class", []string{"file[0-3].txt"}, "/src/file4.txt", false, false, false},
+		{"negated_class", []string{"file[!0-3].txt"}, "/src/file4.txt", false, true, true},
+		{"comment_and_blank", []string{"# *.go", "", "   "}, "/src/main.go", false, false, false},
+		{"escaped_hash", []string{`\#notes`}, "/src/#notes", false, true, true},
+		{"escaped_negation", []string{`\!important`}, "/src/!important", false, true, true},
+		{"trailing_spaces", []string{"tmp   "}, "/src/tmp", true, true, true},
+		{"dot_is_literal", []string{"a.c"}, "/src/abc", false, false, false},
+		{"outside_of_base", []string{"*"}, "/other/file", false, false, false},
+		{"similar_prefix_outside_of_base", []string{"*"}, "/srcx/file", false, false, false},
+		{"base_itself", []string{"*"}, "/src", true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Parse("/src", tt.patterns)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			matched, ignored := rules.Match(tt.path, tt.isDir)
+			if matched != tt.matched || ignored != tt.ignored {
+				t.Errorf("Match() = %v, %v, want %v, %v",
+					matched, ignored, tt.matched, tt.ignored)
+			}
+		})
+	}
+}
+
+func TestRules_MatchRootBase(t *testing.T) {
+	rules, err := Parse("/", []string{"/proc", "node_modules/"})
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, ignored := rules.Match("/proc", true); !ignored {
+		t.Errorf("/proc should be ignored")
+	}
+	if _, ignored := rules.Match("/home/u/proc", true); ignored {
+		t.Errorf("/home/u/proc should not be ignored")
+	}
+	if _, ignored := rules.Match("/home/u/web/node_modules", true); !ignored {
+		t.Errorf("node_modules should be ignored")
+	}
+}
+
+func TestRules_IgnoresParent(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		want     bool
+	}{
+		{"directory_itself", []string{"node_modules/"}, "/src/node_modules", false},
+		{"child", []string{"node_modules/"}, "/src/node_modules/pkg", true},
+		{"descendant", []string{"node_modules/"}, "/src/node_modules/pkg/index.js", true},
+		{"nested", []string{"build/"}, "/src/a/build/x.o", true},
+		{"similar_name", []string{"build/"}, "/src/a/builder/x.o", false},
+		{"anchored", []string{"/build"}, "/src/a/build/x.o", false},
+		{"negated_parent", []string{"build/", "!/a/build/"}, "/src/a/build/x.o", false},
+		{"no_reinclusion", []string{"build/", "!build/x.o"}, "/src/a/build/x.o", true},
+		{"outside_of_base", []string{"*"}, "/other/a/b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Parse("/src", tt.patterns)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := rules.IgnoresParent(tt.path); got != tt.want {
+				t.Errorf("IgnoresParent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRules_CouldMatchBelow(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		dir      string
+		want     bool
+	}{
+		{"floating", []string{"*.conf"}, "/src/a/b", true},
+		{"anchored_parent", []string{".local/bin"}, "/src/.local", true},
+		{"anchored_base", []string{".local/bin"}, "/src", true},
+		{"anchored_other_directory", []string{".local/bin"}, "/src/.cache", false},
+		{"anchored_itself", []string{".local/bin"}, "/src/.local/bin", false},
+		{"anchored_glob", []string{"*/bin/tool"}, "/src/x/bin", true},
+		{"double_star", []string{"a/**/c"}, "/src/a/b/x/y", true},
+		{"negated_class", []string{"[!x]/bin"}, "/src/x", false},
+		{"negated_pattern", []string{"!a/b"}, "/src/a", false},
+		{"outside_of_base", []string{"*.conf"}, "/other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := Parse("/src", tt.patterns)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := rules.CouldMatchBelow(tt.dir); got != tt.want {
+				t.Errorf("CouldMatchBelow(%q) = %v, want %v", tt.dir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check("file[0-9"); err == nil {
+		t.Errorf("Check() of an unterminated class should fail")
+	}
+	if err := Check("**/*.go"); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+}
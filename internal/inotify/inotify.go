@@ -0,0 +1,320 @@
+package inotify
+
+import (
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/karrick/godirwalk"
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/rescan"
+	"github.com/ozeidan/gosearch/internal/watcher"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+const maxWatchesPath = "/proc/sys/fs/inotify/max_user_watches"
+
+var errSkip = errors.New("directory skipped")
+
+// Watcher listens for created/deleted/moved files below its roots
+// by placing an inotify watch on every directory.
+// Directories that can't be watched because the inotify watch limit
+// was reached are periodically rescanned instead.
+type Watcher struct {
+	file  *os.File
+	fd    int
+	roots []string
+
+	mutex        sync.Mutex
+	watches      map[int]string
+	paths        map[string]int
+	unwatched    map[string]bool
+	limitReached bool
+
+	rescanInterval time.Duration
+	done           chan struct{}
+	closeOnce      sync.Once
+}
+
+// New initializes inotify for watching the directories below roots
+// rescanInterval is the interval in which directories that couldn't
+// be watched are rescanned
+func New(roots []string, rescanInterval time.Duration) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("inotify initialized")
+
+	return &Watcher{
+		file:           os.NewFile(uintptr(fd), "inotify"),
+		fd:             fd,
+		roots:          roots,
+		watches:        make(map[int]string),
+		paths:          make(map[string]int),
+		unwatched:      make(map[string]bool),
+		rescanInterval: rescanInterval,
+		done:           make(chan struct{}),
+	}, nil
+}
+
+// Listen adds watches to all directories below the roots and
+// sends the changes of watched directories through changeReceiver
+func (w *Watcher) Listen(changeReceiver chan<- watcher.FileChange) {
+	slog.Info("starting to add inotify watches")
+	for _, root := range w.roots {
+		w.watchRecursively(root)
+	}
+	slog.Info("watching directories with inotify", "directories", len(w.watches))
+
+	go w.rescanUnwatched(changeReceiver)
+
+	buff := make([]byte, 64*unix.SizeofInotifyEvent+unix.PathMax)
+	var backoff watcher.Backoff
+	for {
+		n, err := w.file.Read(buff)
+		if err != nil {
+			select {
+			case <-w.done:
+				return
+			default:
+			}
+			slog.Error("failed to read inotify events", "err", err, "retry_in", backoff.Next())
+			if !backoff.Wait(w.done) {
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+
+		if !w.handleEvents(buff[:n], changeReceiver) {
+			return
+		}
+	}
+}
+
+// handleEvents sends the changes of the events in buff through
+// changeReceiver, it returns false if the watcher was closed
+func (w *Watcher) handleEvents(buff []byte, changeReceiver chan<- watcher.FileChange) bool {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buff); {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buff[offset]))
+		nameStart := offset + unix.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+		offset = nameEnd
+		if nameEnd > len(buff) {
+			return true
+		}
+		name := strings.TrimRight(string(buff[nameStart:nameEnd]), "\x00")
+
+		if event.Mask&unix.IN_Q_OVERFLOW > 0 {
+			slog.Warn("inotify queue overflowed, refreshing all watched directories")
+			watcher.Overflows.With("inotify").Inc()
+			if !w.sendAll(changeReceiver) {
+				return false
+			}
+			continue
+		}
+
+		w.mutex.Lock()
+		dir, ok := w.watches[int(event.Wd)]
+		if event.Mask&unix.IN_IGNORED > 0 {
+			delete(w.watches, int(event.Wd))
+			if ok && w.paths[dir] == int(event.Wd) {
+				delete(w.paths, dir)
+			}
+		}
+		w.mutex.Unlock()
+
+		if !ok || name == "" {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		if config.IsEntryFiltered(path, event.Mask&unix.IN_ISDIR != 0) {
+			continue
+		}
+
+		changeType := watcher.Creation
+		if event.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) > 0 {
+			changeType = watcher.Deletion
+		}
+
+		if event.Mask&unix.IN_ISDIR > 0 {
+			switch {
+			case event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) > 0:
+				w.watchRecursively(path)
+			case event.Mask&unix.IN_MOVED_FROM > 0:
+				w.unwatchRecursively(path)
+			}
+		}
+
+		select {
+		case changeReceiver <- watcher.FileChange{
+			FolderPath: dir,
+			ChangeType: changeType,
+		}:
+		case <-w.done:
+			return false
+		}
+	}
+	return true
+}
+
+// watchRecursively adds watches to path and all directories below it
+func (w *Watcher) watchRecursively(path string) {
+	godirwalk.Walk(path, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if !de.IsDir() {
+				return nil
+			}
+			if config.IsPathFiltered(osPathname) {
+				return errSkip
+			}
+			if !w.addWatch(osPathname) {
+				return errSkip
+			}
+			return nil
+		},
+		Unsorted: true,
+		ErrorCallback: func(_ string, _ error) godirwalk.ErrorAction {
+			return godirwalk.SkipNode
+		},
+	})
+}
+
+func (w *Watcher) addWatch(path string) bool {
+	wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err == unix.ENOSPC {
+		if !w.limitReached {
+			w.limitReached = true
+			slog.Warn("inotify watch limit reached, directories that can't be "+
+				"watched are rescanned, consider raising the limit",
+				"limit", readMaxWatches(), "interval", w.rescanInterval,
+				"setting", maxWatchesPath)
+		}
+		w.unwatched[path] = true
+		return false
+	}
+	if err != nil {
+		return false
+	}
+
+	if oldPath, ok := w.watches[wd]; ok {
+		delete(w.paths, oldPath)
+	}
+	w.watches[wd] = path
+	w.paths[path] = wd
+	return true
+}
+
+// unwatchRecursively removes the watches of path and
+// all directories below it
+func (w *Watcher) unwatchRecursively(path string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	prefix := path + "/"
+	for watchedPath, wd := range w.paths {
+		if watchedPath != path && !strings.HasPrefix(watchedPath, prefix) {
+			continue
+		}
+		unix.InotifyRmWatch(w.fd, uint32(wd))
+		delete(w.paths, watchedPath)
+		delete(w.watches, wd)
+	}
+
+	for unwatchedPath := range w.unwatched {
+		if unwatchedPath == path || strings.HasPrefix(unwatchedPath, prefix) {
+			delete(w.unwatched, unwatchedPath)
+		}
+	}
+}
+
+// sendAll sends a change for every watched directory,
+// it returns false if the watcher was closed
+func (w *Watcher) sendAll(changeReceiver chan<- watcher.FileChange) bool {
+	w.mutex.Lock()
+	dirs := make([]string, 0, len(w.paths))
+	for path := range w.paths {
+		dirs = append(dirs, path)
+	}
+	w.mutex.Unlock()
+
+	for _, dir := range dirs {
+		select {
+		case changeReceiver <- watcher.FileChange{FolderPath: dir}:
+		case <-w.done:
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) rescanUnwatched(changeReceiver chan<- watcher.FileChange) {
+	scanner := rescan.NewScanner()
+	ticker := time.NewTicker(w.rescanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+
+		w.mutex.Lock()
+		roots := make([]string, 0, len(w.unwatched))
+		for path := range w.unwatched {
+			roots = append(roots, path)
+		}
+		w.mutex.Unlock()
+
+		if len(roots) == 0 {
+			continue
+		}
+
+		scanner.Scan(roots, func(dir string) {
+			select {
+			case changeReceiver <- watcher.FileChange{FolderPath: dir}:
+			case <-w.done:
+			}
+		})
+	}
+}
+
+func readMaxWatches() string {
+	limit, err := ioutil.ReadFile(maxWatchesPath)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(limit))
+}
+
+// Close removes all watches and stops listening for events
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+// Name returns the name of the backend
+func (w *Watcher) Name() string {
+	return "inotify"
+}
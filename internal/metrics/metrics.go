@@ -0,0 +1,365 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets
+// for durations in seconds
+var DefaultBuckets = []float64{
+	.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+// collector is a metric that can write itself in the Prometheus text format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var registry struct {
+	sync.Mutex
+	collectors []collector
+}
+
+func register(c collector) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, other := range registry.collectors {
+		if other.name() == c.name() {
+			panic("metrics: duplicate metric " + c.name())
+		}
+	}
+	registry.collectors = append(registry.collectors, c)
+}
+
+// WriteText writes all registered metrics in the Prometheus text format
+func WriteText(w io.Writer) error {
+	registry.Lock()
+	collectors := append([]collector{}, registry.collectors...)
+	registry.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	buffered := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+// Handler returns an http.Handler that serves the metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := WriteText(w); err != nil {
+			slog.Error("failed to write metrics", "err", err)
+		}
+	})
+}
+
+// NewServer returns an http.Server that serves the metrics
+// on /metrics at address
+func NewServer(address string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	return &http.Server{Addr: address, Handler: mux}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabel(label, value string) string {
+	if label == "" {
+		return ""
+	}
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return fmt.Sprintf(`%s="%s"`, label, value)
+}
+
+func joinLabels(labels ...string) string {
+	var nonEmpty []string
+	for _, l := range labels {
+		if l != "" {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(nonEmpty, ",") + "}"
+}
+
+// Counter is a value that only increases
+type Counter struct {
+	value uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	bits uint64
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Add adds v to the gauge
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&g.bits, old, updated) {
+			return
+		}
+	}
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     Gauge
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe adds the observation v
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(v)
+}
+
+func (h *Histogram) write(w io.Writer, name, label string) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name,
+			joinLabels(label, formatLabel("le", formatFloat(bound))), cumulative)
+	}
+	count := atomic.LoadUint64(&h.count)
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, joinLabels(label, `le="+Inf"`), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, joinLabels(label), formatFloat(h.sum.Value()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, joinLabels(label), count)
+}
+
+type counterMetric struct {
+	metricName, help string
+	Counter
+}
+
+func (c *counterMetric) name() string { return c.metricName }
+
+func (c *counterMetric) write(w io.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.metricName, c.Value())
+}
+
+// NewCounter registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &counterMetric{metricName: name, help: help}
+	register(c)
+	return &c.Counter
+}
+
+type gaugeMetric struct {
+	metricName, help string
+	Gauge
+}
+
+func (g *gaugeMetric) name() string { return g.metricName }
+
+func (g *gaugeMetric) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.Value()))
+}
+
+// NewGauge registers a gauge
+func NewGauge(name, help string) *Gauge {
+	g := &gaugeMetric{metricName: name, help: help}
+	register(g)
+	return &g.Gauge
+}
+
+type funcMetric struct {
+	metricName, help, kind string
+	value                  func() float64
+}
+
+func (f *funcMetric) name() string { return f.metricName }
+
+func (f *funcMetric) write(w io.Writer) {
+	writeHeader(w, f.metricName, f.help, f.kind)
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatFloat(f.value()))
+}
+
+// NewCounterFunc registers a counter whose value is returned by value,
+// for counters that are kept elsewhere
+func NewCounterFunc(name, help string, value func() float64) {
+	register(&funcMetric{name, help, "counter", value})
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by value
+func NewGaugeFunc(name, help string, value func() float64) {
+	register(&funcMetric{name, help, "gauge", value})
+}
+
+type histogramMetric struct {
+	metricName, help string
+	*Histogram
+}
+
+func (h *histogramMetric) name() string { return h.metricName }
+
+func (h *histogramMetric) write(w io.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+	h.Histogram.write(w, h.metricName, "")
+}
+
+// NewHistogram registers a histogram with the upper bounds buckets
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &histogramMetric{name, help, newHistogram(buckets)}
+	register(h)
+	return h.Histogram
+}
+
+// vec holds the children of a metric with one label,
+// keyed by the label value
+type vec struct {
+	metricName, help, label string
+	mutex                   sync.Mutex
+	children                map[string]interface{}
+}
+
+func (v *vec) name() string { return v.metricName }
+
+func (v *vec) child(value string, create func() interface{}) interface{} {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	c, ok := v.children[value]
+	if !ok {
+		c = create()
+		v.children[value] = c
+	}
+	return c
+}
+
+func (v *vec) sortedValues() []string {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	values := make([]string, 0, len(v.children))
+	for value := range v.children {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// CounterVec is a set of counters that are distinguished by a label
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec registers a counter with the label label
+func NewCounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{vec{name, help, label, sync.Mutex{}, make(map[string]interface{})}}
+	register(v)
+	return v
+}
+
+// With returns the counter for the label value
+func (v *CounterVec) With(value string) *Counter {
+	return v.child(value, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	writeHeader(w, v.metricName, v.help, "counter")
+	for _, value := range v.sortedValues() {
+		fmt.Fprintf(w, "%s%s %d\n", v.metricName,
+			joinLabels(formatLabel(v.label, value)), v.With(value).Value())
+	}
+}
+
+// HistogramVec is a set of histograms that are distinguished by a label
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram with the label label
+// and the upper bounds buckets
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	v := &HistogramVec{
+		vec{name, help, label, sync.Mutex{}, make(map[string]interface{})},
+		buckets,
+	}
+	register(v)
+	return v
+}
+
+// With returns the histogram for the label value
+func (v *HistogramVec) With(value string) *Histogram {
+	return v.child(value, func() interface{} {
+		return newHistogram(v.buckets)
+	}).(*Histogram)
+}
+
+func (v *HistogramVec) write(w io.Writer) {
+	writeHeader(w, v.metricName, v.help, "histogram")
+	for _, value := range v.sortedValues() {
+		v.With(value).write(w, v.metricName, formatLabel(v.label, value))
+	}
+}
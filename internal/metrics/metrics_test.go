@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	counter := NewCounter("test_counter_total", "A counter.")
+	counter.Add(3)
+	counter.Inc()
+
+	gauge := NewGauge("test_gauge", "A gauge.")
+	gauge.Set(2.5)
+	gauge.Add(-1)
+
+	vec := NewCounterVec("test_vec_total", "A counter vector.", "kind")
+	vec.With("b").Inc()
+	vec.With(`a"quoted"`).Add(2)
+
+	histogram := NewHistogram("test_histogram_seconds", "A histogram.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.1)
+	histogram.Observe(5)
+
+	NewGaugeFunc("test_func", "A gauge function.", func() float64 { return 7 })
+
+	var buff bytes.Buffer
+	if err := WriteText(&buff); err != nil {
+		t.Fatal(err)
+	}
+	text := buff.String()
+
+	for _, want := range []string{
+		"# HELP test_counter_total A counter.\n# TYPE test_counter_total counter\ntest_counter_total 4\n",
+		"# TYPE test_gauge gauge\ntest_gauge 1.5\n",
+		"test_vec_total{kind=\"a\\\"quoted\\\"\"} 2\ntest_vec_total{kind=\"b\"} 1\n",
+		"# TYPE test_histogram_seconds histogram\n" +
+			"test_histogram_seconds_bucket{le=\"0.1\"} 2\n" +
+			"test_histogram_seconds_bucket{le=\"1\"} 2\n" +
+			"test_histogram_seconds_bucket{le=\"+Inf\"} 3\n" +
+			"test_histogram_seconds_sum 5.15\n" +
+			"test_histogram_seconds_count 3\n",
+		"# TYPE test_func gauge\ntest_func 7\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, text)
+		}
+	}
+
+	if strings.Index(text, "test_counter_total") > strings.Index(text, "test_vec_total") {
+		t.Errorf("metrics are not sorted by name")
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	vec := NewHistogramVec("test_histogram_vec", "A histogram vector.", "action", []float64{1})
+	vec.With("fuzzy").Observe(2)
+
+	var buff bytes.Buffer
+	if err := WriteText(&buff); err != nil {
+		t.Fatal(err)
+	}
+	want := "test_histogram_vec_bucket{action=\"fuzzy\",le=\"1\"} 0\n" +
+		"test_histogram_vec_bucket{action=\"fuzzy\",le=\"+Inf\"} 1\n" +
+		"test_histogram_vec_sum{action=\"fuzzy\"} 2\n"
+	if !strings.Contains(buff.String(), want) {
+		t.Errorf("output doesn't contain %q:\n%s", want, buff.String())
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	NewCounter("test_duplicate_total", "A counter.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a duplicate metric didn't panic")
+		}
+	}()
+	NewCounter("test_duplicate_total", "A counter.")
+}
@@ -1,15 +1,83 @@
 package request
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
 )
 
-// SockAddr is the path at which the unix domain socket is created
+// SockAddr is the path at which the unix domain socket of
+// the system-wide server is created
 const SockAddr = "/run/gosearch.sock"
 
+// UserSockAddr returns the path at which the unix domain socket
+// of a server running in user mode is created
+func UserSockAddr() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if !filepath.IsAbs(runtimeDir) {
+		runtimeDir = fallbackSockDir()
+	}
+	return filepath.Join(runtimeDir, "gosearch.sock")
+}
+
+// fallbackSockDir returns the directory of the socket of a server
+// running in user mode if XDG_RUNTIME_DIR isn't set, other users
+// may create it first, as it is in the temporary directory
+func fallbackSockDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gosearch-%d", os.Getuid()))
+}
+
+// CheckSockDir returns an error if the directory of the socket at
+// sockAddr is the fallback directory of UserSockAddr and isn't a
+// directory that only the current user has access to
+func CheckSockDir(sockAddr string) error {
+	dir := filepath.Dir(sockAddr)
+	if dir != fallbackSockDir() {
+		return nil
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s isn't owned by the current user", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is accessible by other users", dir)
+	}
+	return nil
+}
+
+// makeSockDir creates the directory of the socket at sockAddr
+func makeSockDir(sockAddr string) error {
+	dir := filepath.Dir(sockAddr)
+	if dir != fallbackSockDir() {
+		return os.MkdirAll(dir, 0700)
+	}
+	// the permissions of an existing directory aren't changed,
+	// it may belong to another user
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return CheckSockDir(sockAddr)
+}
+
+// SockAddrs returns the paths at which a server's socket may be found,
+// in the order they should be tried by clients
+func SockAddrs() []string {
+	return []string{UserSockAddr(), SockAddr}
+}
+
 const (
 	SubStringSearch = iota
 	// PrefixSearch denotes searching for prefixes of file/directory names
@@ -18,8 +86,29 @@ const (
 	FuzzySearch
 	// IndexRefresh refreshes the whole database over the files
 	IndexRefresh
+	// Status returns a JSON document describing the server's state
+	Status
+	// RegexSearch matches file/directory names against a regular expression
+	RegexSearch
 )
 
+var actionNames = map[int]string{
+	SubStringSearch: "substring",
+	PrefixSearch:    "prefix",
+	FuzzySearch:     "fuzzy",
+	IndexRefresh:    "refresh",
+	Status:          "status",
+	RegexSearch:     "regex",
+}
+
+// ActionName returns a short name of action for logs and metrics
+func ActionName(action int) string {
+	if name, ok := actionNames[action]; ok {
+		return name
+	}
+	return "unknown"
+}
+
 // Request holds the details of a request
 // that was received over the unix domain socket
 type Request struct {
@@ -44,42 +133,208 @@ type Settings struct {
 	// Don't sort the query results when
 	NoSort bool `json:"no_sort"`
 	// ReverseSort sets the sort-order to ascending in length
-	ReverseSort     bool `json:"reverse_sort"`
+	ReverseSort bool `json:"reverse_sort"`
+	// CaseInsensitive compares names using full Unicode case folding,
+	// regular expressions use simple case folding
 	CaseInsensitive bool `json:"case_insensitive"`
+	// SmartCase makes searches case insensitive if the query contains
+	// no upper-case letters, for regular expressions only the literals
+	// are checked, CaseInsensitive takes precedence
+	SmartCase bool `json:"smart_case"`
+	// AccentInsensitive ignores accents and other combining marks,
+	// so resume matches résumé
+	AccentInsensitive bool `json:"accent_insensitive"`
+	// Structured makes the server send every line of the response
+	// as a JSON encoded Response instead of plain text
+	Structured bool `json:"structured"`
+}
+
+// Response is a line of the response to a request
+type Response struct {
+	// Path is the path of a search result
+	Path string `json:"path,omitempty"`
+	// Notice informs about the state of the server, e.g. that
+	// the query couldn't be answered because the index isn't built yet,
+	// notices are only sent to clients that set Structured
+	Notice string `json:"notice,omitempty"`
+	// Status holds the status document of the server
+	Status json.RawMessage `json:"status,omitempty"`
+	// Partial is set when the response was created
+	// before the initial index was built
+	Partial bool `json:"partial,omitempty"`
+	// Matches holds the byte offsets in Path of the characters
+	// that were matched by a fuzzy search
+	Matches []int `json:"matches,omitempty"`
+}
+
+// String returns the plain text form of the response,
+// notices don't have one
+func (r Response) String() string {
+	if r.Status != nil {
+		return string(r.Status)
+	}
+	return r.Path
+}
+
+// Send sends response to the client in the format it requested,
+// it returns false if the client doesn't want any more responses
+func (r Request) Send(response Response) bool {
+	if response.Notice != "" && !r.Settings.Structured {
+		// plain text clients can't tell notices from results
+		return true
+	}
+	line := response.String()
+	if r.Settings.Structured {
+		responseBytes, err := json.Marshal(response)
+		if err != nil {
+			slog.Error("failed to encode response", "err", err)
+			return false
+		}
+		line = string(responseBytes)
+	}
+
+	select {
+	case r.ResponseChannel <- line:
+		return true
+	case <-r.Done:
+		return false
+	}
 }
 
-// ListenAndServe starts listening for and accepting requests
-// on a unix domain socket.
+// Server accepts requests on a unix domain socket
+// and passes them on to the database
+type Server struct {
+	listener net.Listener
+	// sockAddr is the path of the socket, which is removed on shutdown,
+	// it is empty if the socket wasn't created by the server
+	sockAddr        string
+	requestReceiver chan<- Request
+
+	mutex  sync.Mutex
+	closed bool
+	// conn is the connection that is currently served
+	conn net.Conn
+	// quit is closed when the active request is cancelled
+	quit chan struct{}
+	// done is closed when Serve returned
+	done chan struct{}
+}
+
+// Listen creates the unix domain socket at sockAddr,
 // requestReceiver is used for passing on the requests to the caller
-func ListenAndServe(requestReceiver chan<- Request) {
-	if err := os.RemoveAll(SockAddr); err != nil {
-		log.Fatal(err)
+func Listen(sockAddr string, requestReceiver chan<- Request) (*Server, error) {
+	if err := makeSockDir(sockAddr); err != nil {
+		return nil, fmt.Errorf("couldn't create the socket directory: %w", err)
+	}
+	if err := os.RemoveAll(sockAddr); err != nil {
+		return nil, fmt.Errorf("couldn't remove the old socket: %w", err)
 	}
 
-	l, err := net.Listen("unix", SockAddr)
+	l, err := net.Listen("unix", sockAddr)
 	if err != nil {
-		log.Fatal("listen error:", err)
+		return nil, fmt.Errorf("listen error: %w", err)
 	}
-	defer l.Close()
 
-	setSocketPermissions()
+	err = setSocketPermissions(sockAddr)
 	if err != nil {
-		log.Fatal("couldn't set socket permissions properly", err)
+		l.Close()
+		return nil, fmt.Errorf("couldn't set socket permissions properly: %w", err)
 	}
 
+	s := NewServer(l, requestReceiver)
+	s.sockAddr = sockAddr
+	return s, nil
+}
+
+// NewServer returns a server that accepts requests on l, which may
+// have been created by the service manager, the socket of l isn't
+// removed on shutdown
+func NewServer(l net.Listener, requestReceiver chan<- Request) *Server {
+	return &Server{
+		listener:        l,
+		requestReceiver: requestReceiver,
+		quit:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+}
+
+// Serve accepts and answers connections one after another
+// until the server is shut down
+func (s *Server) Serve() {
+	defer close(s.done)
 	for {
-		conn, err := l.Accept()
+		conn, err := s.listener.Accept()
 
 		if err != nil {
-			log.Println("accept error:", err)
+			if s.isClosed() {
+				return
+			}
+			slog.Error("accept error", "err", err)
 			continue
 		}
 
-		serve(conn, requestReceiver)
+		if !s.setConn(conn) {
+			conn.Close()
+			return
+		}
+		s.serve(conn)
+		s.setConn(nil)
+	}
+}
+
+// Shutdown stops accepting connections and waits until the request
+// that is being answered is done, it is cancelled when ctx is done.
+// The socket is removed afterwards
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+	err := s.listener.Close()
+
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		close(s.quit)
+		s.mutex.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mutex.Unlock()
+		<-s.done
+		err = ctx.Err()
+	}
+
+	if s.sockAddr == "" {
+		return err
 	}
+	if removeErr := os.Remove(s.sockAddr); removeErr != nil &&
+		!os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+func (s *Server) isClosed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closed
+}
+
+// setConn sets the connection that is currently served,
+// it returns false if the server is shut down
+func (s *Server) setConn(conn net.Conn) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.conn = conn
+	return !s.closed
 }
 
-func setSocketPermissions() error {
+func setSocketPermissions(sockAddr string) error {
+	// sockets of user mode servers are only accessible by their user
+	if sockAddr != SockAddr {
+		return os.Chmod(sockAddr, 0600)
+	}
+
 	// group, err := user.LookupGroup("users")
 	// if err != nil {
 	// 	return err
@@ -93,7 +348,7 @@ func setSocketPermissions() error {
 	// 	return err
 	// }
 
-	err := os.Chmod(SockAddr, os.ModePerm)
+	err := os.Chmod(sockAddr, os.ModePerm)
 	if err != nil {
 		return err
 	}
@@ -101,32 +356,48 @@ func setSocketPermissions() error {
 	return nil
 }
 
-func serve(c net.Conn, requestReceiver chan<- Request) {
+func (s *Server) serve(c net.Conn) {
 	defer c.Close()
 	request := Request{}
 	err := json.NewDecoder(c).Decode(&request)
 
 	if err != nil {
 		// TODO: send error back
-		log.Println("failed to decode request:", err)
+		slog.Warn("failed to decode request", "err", err)
 		return
 	}
 
 	request.ResponseChannel = make(chan string)
 	request.Done = make(chan struct{})
-	requestReceiver <- request
+	select {
+	case s.requestReceiver <- request:
+	case <-s.quit:
+		return
+	}
+
+	for {
+		var response string
+		select {
+		case r, ok := <-request.ResponseChannel:
+			if !ok {
+				return
+			}
+			response = r
+		case <-s.quit:
+			close(request.Done)
+			return
+		}
 
-	for response := range request.ResponseChannel {
 		responseBytes := []byte(response + "\n")
 		n, err := c.Write(responseBytes)
 		if n != len(responseBytes) {
-			log.Printf("wrote %d bytes, expected to write %d", n, len(responseBytes))
+			slog.Warn("short write to unix domain socket",
+				"written", n, "expected", len(responseBytes))
 		}
 		if err != nil {
-			log.Println("failed to write to unix domain socket:", err)
-			request.Done <- struct{}{}
-			break
+			slog.Warn("failed to write to unix domain socket", "err", err)
+			close(request.Done)
+			return
 		}
-
 	}
 }
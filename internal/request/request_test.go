@@ -0,0 +1,182 @@
+package request
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	tests := []struct {
+		name string
+		// answer makes the database answer the request
+		answer  bool
+		wantErr error
+	}{
+		{"drains_active_request", true, nil},
+		{"cancels_unanswered_request", false, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "gosearch-request")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			sockAddr := filepath.Join(dir, "gosearch.sock")
+			requests := make(chan Request)
+			server, err := Listen(sockAddr, requests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve()
+
+			c, err := net.Dial("unix", sockAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if err := json.NewEncoder(c).Encode(Request{Query: "query"}); err != nil {
+				t.Fatal(err)
+			}
+			req := <-requests
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			shutdownErr := make(chan error)
+			go func() {
+				shutdownErr <- server.Shutdown(ctx)
+			}()
+
+			if tt.answer {
+				req.Send(Response{Path: "/result"})
+				close(req.ResponseChannel)
+				line, err := bufio.NewReader(c).ReadString('\n')
+				if err != nil || line != "/result\n" {
+					t.Errorf("received %q, %v, want the result", line, err)
+				}
+			} else {
+				<-req.Done
+			}
+
+			if err := <-shutdownErr; err != tt.wantErr {
+				t.Errorf("Shutdown() = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := os.Stat(sockAddr); !os.IsNotExist(err) {
+				t.Errorf("the socket wasn't removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewServer_KeepsSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// sockets passed by the service manager aren't removed
+	sockAddr := filepath.Join(dir, "gosearch.sock")
+	l, err := net.Listen("unix", sockAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	server := NewServer(l, make(chan Request))
+	go server.Serve()
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+	if _, err := os.Stat(sockAddr); err != nil {
+		t.Errorf("the socket was removed: %v", err)
+	}
+}
+
+func TestMakeSockDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv("TMPDIR", dir)
+	t.Setenv("XDG_RUNTIME_DIR", "")
+
+	sockAddr := UserSockAddr()
+	sockDir := filepath.Dir(sockAddr)
+	if err := CheckSockDir(sockAddr); !os.IsNotExist(err) {
+		t.Errorf("CheckSockDir() = %v before the directory was created", err)
+	}
+	if err := makeSockDir(sockAddr); err != nil {
+		t.Fatalf("makeSockDir() = %v", err)
+	}
+	if err := CheckSockDir(sockAddr); err != nil {
+		t.Errorf("CheckSockDir() = %v", err)
+	}
+
+	// directories that others have access to aren't used
+	if err := os.Chmod(sockDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeSockDir(sockAddr); err == nil {
+		t.Errorf("makeSockDir() accepted a directory accessible by others")
+	}
+
+	// neither are symlinks to directories
+	target := filepath.Join(dir, "target")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(sockDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, sockDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSockDir(sockAddr); err == nil {
+		t.Errorf("CheckSockDir() accepted a symlink")
+	}
+
+	// other directories aren't checked
+	if err := CheckSockDir(filepath.Join(target, "gosearch.sock")); err != nil {
+		t.Errorf("CheckSockDir() = %v for a socket outside of the fallback directory", err)
+	}
+}
+
+func TestRequest_Send(t *testing.T) {
+	tests := []struct {
+		name       string
+		structured bool
+		response   Response
+		want       string
+	}{
+		{"path", false, Response{Path: "/result"}, "/result"},
+		// plain text clients don't get notices
+		{"notice", false, Response{Notice: "indexing", Partial: true}, ""},
+		{"structured_notice", true, Response{Notice: "indexing", Partial: true},
+			`{"notice":"indexing","partial":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := Request{
+				Settings:        Settings{Structured: tt.structured},
+				ResponseChannel: make(chan string, 1),
+			}
+			if !req.Send(tt.response) {
+				t.Fatal("Send() = false, want true")
+			}
+			close(req.ResponseChannel)
+			if got := <-req.ResponseChannel; got != tt.want {
+				t.Errorf("Send() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
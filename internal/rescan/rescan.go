@@ -0,0 +1,121 @@
+package rescan
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/karrick/godirwalk"
+	"github.com/ozeidan/gosearch/internal/config"
+	"github.com/ozeidan/gosearch/internal/watcher"
+)
+
+var errSkip = errors.New("directory skipped")
+
+// Scanner detects changed directories by comparing
+// their modification times between scans
+type Scanner struct {
+	mtimes map[string]time.Time
+}
+
+// NewScanner returns a new Scanner
+func NewScanner() *Scanner {
+	return &Scanner{make(map[string]time.Time)}
+}
+
+// Scan walks the directories below roots and calls changed for every
+// directory whose modification time differs from the previous scan.
+// Directories that are seen for the first time are only recorded,
+// their contents are picked up by the refresh of their parent.
+func (s *Scanner) Scan(roots []string, changed func(dir string)) {
+	mtimes := make(map[string]time.Time, len(s.mtimes))
+
+	for _, root := range roots {
+		godirwalk.Walk(root, &godirwalk.Options{
+			Callback: func(osPathname string, de *godirwalk.Dirent) error {
+				if config.IsPathFiltered(osPathname) {
+					return errSkip
+				}
+				if !de.IsDir() {
+					return nil
+				}
+
+				info, err := os.Lstat(osPathname)
+				if err != nil {
+					return errSkip
+				}
+
+				mtime := info.ModTime()
+				if old, ok := s.mtimes[osPathname]; ok && !old.Equal(mtime) {
+					changed(osPathname)
+				}
+				mtimes[osPathname] = mtime
+				return nil
+			},
+			Unsorted: true,
+			ErrorCallback: func(_ string, _ error) godirwalk.ErrorAction {
+				return godirwalk.SkipNode
+			},
+		})
+	}
+
+	s.mtimes = mtimes
+}
+
+// Watcher periodically rescans its roots for changed directories,
+// it is used when the kernel or file system doesn't support
+// fanotify or inotify
+type Watcher struct {
+	roots     []string
+	interval  time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New returns a Watcher that rescans roots every interval
+func New(roots []string, interval time.Duration) *Watcher {
+	return &Watcher{
+		roots:    roots,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+}
+
+// Listen scans the roots in the configured interval and sends the
+// changed directories through changeReceiver
+func (w *Watcher) Listen(changeReceiver chan<- watcher.FileChange) {
+	slog.Info("starting to rescan", "roots", w.roots, "interval", w.interval)
+	scanner := NewScanner()
+	send := func(dir string) {
+		select {
+		case changeReceiver <- watcher.FileChange{FolderPath: dir}:
+		case <-w.done:
+		}
+	}
+
+	scanner.Scan(w.roots, send)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			scanner.Scan(w.roots, send)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// Close stops the rescanning
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	return nil
+}
+
+// Name returns the name of the backend
+func (w *Watcher) Name() string {
+	return "rescan"
+}
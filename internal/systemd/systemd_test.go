@@ -0,0 +1,89 @@
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosearch-systemd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socketPath := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+
+	sent, err := Notify("READY=1")
+	if !sent || err != nil {
+		t.Fatalf("Notify() = %v, %v", sent, err)
+	}
+
+	buff := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buff[:n]); got != "READY=1" {
+		t.Errorf("received %q, want %q", got, "READY=1")
+	}
+}
+
+func TestNotify_NoSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Errorf("Notify() = %v, %v, want false, nil", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name         string
+		usec         string
+		pid          string
+		wantInterval time.Duration
+		wantEnabled  bool
+	}{
+		{"enabled", "30000000", pid, 30 * time.Second, true},
+		{"enabled_without_pid", "500000", "", 500 * time.Millisecond, true},
+		{"other_process", "30000000", "1", 0, false},
+		{"disabled", "", "", 0, false},
+		{"invalid", "soon", pid, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			interval, enabled := WatchdogInterval()
+			if interval != tt.wantInterval || enabled != tt.wantEnabled {
+				t.Errorf("WatchdogInterval() = %v, %v, want %v, %v",
+					interval, enabled, tt.wantInterval, tt.wantEnabled)
+			}
+		})
+	}
+}
+
+func TestListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := Listeners()
+	if len(listeners) != 0 || err != nil {
+		t.Errorf("Listeners() = %v, %v, want no listeners", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("LISTEN_FDS wasn't unset")
+	}
+}
@@ -0,0 +1,47 @@
+package watcher
+
+import "time"
+
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = time.Minute
+)
+
+// Backoff delays the retries of a backend after errors, so persistent
+// errors don't keep it spinning, the delay doubles with every error up
+// to a minute and starts over after a success
+type Backoff struct {
+	delay time.Duration
+}
+
+// Next returns the delay before the next retry
+func (b *Backoff) Next() time.Duration {
+	if b.delay == 0 {
+		return minBackoff
+	}
+	return b.delay
+}
+
+// Wait waits for the next retry, it returns false
+// if done is closed in the meantime
+func (b *Backoff) Wait(done <-chan struct{}) bool {
+	delay := b.Next()
+	b.delay = delay * 2
+	if b.delay > maxBackoff {
+		b.delay = maxBackoff
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Reset starts over with the shortest delay after a success
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
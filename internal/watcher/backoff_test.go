@@ -0,0 +1,34 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	var b Backoff
+	// the delays grow without waiting for them
+	done := make(chan struct{})
+	close(done)
+
+	for _, want := range []time.Duration{minBackoff, 2 * minBackoff, 4 * minBackoff} {
+		if got := b.Next(); got != want {
+			t.Errorf("Next() = %v, want %v", got, want)
+		}
+		if b.Wait(done) {
+			t.Errorf("Wait() returned true after done was closed")
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		b.Wait(done)
+	}
+	if got := b.Next(); got != maxBackoff {
+		t.Errorf("Next() = %v, want the maximum %v", got, maxBackoff)
+	}
+
+	b.Reset()
+	if got := b.Next(); got != minBackoff {
+		t.Errorf("Next() = %v after Reset, want %v", got, minBackoff)
+	}
+}
@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ozeidan/gosearch/internal/metrics"
+)
+
+// Overflows counts the event queue overflows of the backends,
+// changes may have been lost when they occur
+var Overflows = metrics.NewCounterVec("gosearch_watcher_overflows_total",
+	"Number of event queue overflows by watcher backend.", "backend")
+
+// FileChange describes the event of changes in a directory
+// FolderPath is the path of the directory
+// Changetype is either Creation or Deletion
+// Descendants holds directories below FolderPath that also changed,
+// it is only set on changes that were merged by the coalescer
+type FileChange struct {
+	FolderPath  string
+	ChangeType  int
+	Descendants []string
+}
+
+const (
+	// Creation of a file/directory
+	Creation = iota
+	// Deletion of a file/directory
+	Deletion
+)
+
+// Watcher is a backend that watches the file system for
+// created/deleted/moved files
+type Watcher interface {
+	// Listen starts listening for changes and sends them through
+	// changeReceiver, it blocks until the watcher is closed
+	Listen(changeReceiver chan<- FileChange)
+	// Close stops the watcher and releases its resources
+	Close() error
+	// Name returns the name of the backend
+	Name() string
+}
+
+type multiWatcher []Watcher
+
+// Multi combines watchers into a single Watcher
+// that reports the changes of all of them
+func Multi(watchers ...Watcher) Watcher {
+	if len(watchers) == 1 {
+		return watchers[0]
+	}
+	return multiWatcher(watchers)
+}
+
+func (m multiWatcher) Listen(changeReceiver chan<- FileChange) {
+	var wg sync.WaitGroup
+	for _, w := range m {
+		wg.Add(1)
+		go func(w Watcher) {
+			defer wg.Done()
+			w.Listen(changeReceiver)
+		}(w)
+	}
+	wg.Wait()
+}
+
+func (m multiWatcher) Close() error {
+	var firstErr error
+	for _, w := range m {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m multiWatcher) Name() string {
+	names := make([]string, 0, len(m))
+	for _, w := range m {
+		names = append(names, w.Name())
+	}
+	return strings.Join(names, "+")
+}
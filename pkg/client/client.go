@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
+	"os"
 
 	"github.com/ozeidan/gosearch/internal/request"
 )
@@ -21,6 +23,10 @@ func PrefixSearch(req *request.Request) {
 	req.Settings.Action = request.PrefixSearch
 }
 
+func Regex(req *request.Request) {
+	req.Settings.Action = request.RegexSearch
+}
+
 func NoSort(req *request.Request) {
 	req.Settings.NoSort = true
 }
@@ -33,6 +39,14 @@ func CaseInsensitive(req *request.Request) {
 	req.Settings.CaseInsensitive = true
 }
 
+func SmartCase(req *request.Request) {
+	req.Settings.SmartCase = true
+}
+
+func AccentInsensitive(req *request.Request) {
+	req.Settings.AccentInsensitive = true
+}
+
 func MaxResults(max int) Option {
 	return func(req *request.Request) {
 		req.Settings.MaxResults = max
@@ -49,10 +63,9 @@ func SearchRequest(searchQuery string, options ...Option) (<-chan string, error)
 		option(req)
 	}
 
-	c, err := net.Dial("unix", request.SockAddr)
-
+	c, err := dial()
 	if err != nil {
-		return nil, ErrConnectionFailed
+		return nil, err
 	}
 
 	err = json.NewEncoder(c).Encode(&req)
@@ -76,3 +89,72 @@ func SearchRequest(searchQuery string, options ...Option) (<-chan string, error)
 
 	return responseChan, nil
 }
+
+// Search sends a search request to the server and returns
+// the lines of its response, which are either results or notices
+func Search(searchQuery string, options ...Option) (<-chan request.Response, error) {
+	options = append(options, func(req *request.Request) {
+		req.Settings.Structured = true
+	})
+	lines, err := SearchRequest(searchQuery, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	responseChan := make(chan request.Response)
+	go func() {
+		defer close(responseChan)
+		for line := range lines {
+			var response request.Response
+			if err := json.Unmarshal([]byte(line), &response); err != nil {
+				response = request.Response{Notice: "invalid response: " + err.Error()}
+			}
+			responseChan <- response
+		}
+	}()
+	return responseChan, nil
+}
+
+// Status requests the status document of the server
+func Status() (json.RawMessage, error) {
+	responses, err := Search("", func(req *request.Request) {
+		req.Settings.Action = request.Status
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var status json.RawMessage
+	for response := range responses {
+		if response.Status != nil {
+			status = response.Status
+		}
+	}
+	if status == nil {
+		return nil, errors.New("the server didn't send its status")
+	}
+	return status, nil
+}
+
+// dial connects to the first server socket that accepts connections,
+// a server running in user mode is preferred over the system-wide one
+func dial() (net.Conn, error) {
+	var dirErr error
+	for _, sockAddr := range request.SockAddrs() {
+		// sockets in directories that other users control aren't trusted
+		if err := request.CheckSockDir(sockAddr); err != nil {
+			if !os.IsNotExist(err) {
+				dirErr = fmt.Errorf("refusing to connect to %s: %w", sockAddr, err)
+			}
+			continue
+		}
+		c, err := net.Dial("unix", sockAddr)
+		if err == nil {
+			return c, nil
+		}
+	}
+	if dirErr != nil {
+		return nil, dirErr
+	}
+	return nil, ErrConnectionFailed
+}
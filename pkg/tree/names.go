@@ -0,0 +1,192 @@
+package tree
+
+import "encoding/binary"
+
+// minGarbage is the number of bytes of removed names from which on
+// the name storage is compacted when more than half of it is unused
+const minGarbage = 1 << 16
+
+// minSlots is the initial size of the hash table of the names
+const minSlots = 16
+
+// removedSlot marks a slot of a removed name in the hash table
+const removedSlot = none
+
+// nameTable stores every distinct name once in a shared buffer,
+// names are referred to by their id and removed when no entry
+// uses them anymore
+type nameTable struct {
+	// data holds the names prefixed by their length
+	data  []byte
+	names []name
+	free  []uint32
+	// slots is an open addressing hash table of the ids of the names,
+	// offset by one, so zero marks an empty slot
+	slots []uint32
+	// used is the number of slots that aren't empty
+	used int
+	// garbage is the number of bytes of removed names in data
+	garbage int
+}
+
+type name struct {
+	offset uint32
+	refs   uint32
+}
+
+func newNameTable() nameTable {
+	return nameTable{slots: make([]uint32, minSlots)}
+}
+
+// hash returns the FNV-1a hash of s
+func hash(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// get returns the name with the given id and
+// the number of bytes it takes up in the storage
+func (n *nameTable) get(id uint32) ([]byte, int) {
+	offset := n.names[id].offset
+	length, prefix := binary.Uvarint(n.data[offset:])
+	start := offset + uint32(prefix)
+	return n.data[start : start+uint32(length)], prefix + int(length)
+}
+
+func (n *nameTable) bytes(id uint32) []byte {
+	b, _ := n.get(id)
+	return b
+}
+
+func (n *nameTable) text(id uint32) string {
+	return string(n.bytes(id))
+}
+
+// slot returns the slot of the name s in the hash table
+// and whether s is stored
+func (n *nameTable) slot(s string) (int, bool) {
+	mask := uint64(len(n.slots) - 1)
+	for i := hash(s) & mask; ; i = (i + 1) & mask {
+		switch slot := n.slots[i]; slot {
+		case 0:
+			return int(i), false
+		case removedSlot:
+		default:
+			if string(n.bytes(slot-1)) == s {
+				return int(i), true
+			}
+		}
+	}
+}
+
+// id returns the id of s, it returns false if no entry has the name s
+func (n *nameTable) id(s string) (uint32, bool) {
+	i, ok := n.slot(s)
+	if !ok {
+		return none, false
+	}
+	return n.slots[i] - 1, true
+}
+
+// intern returns the id of s, storing it if it isn't stored yet
+func (n *nameTable) intern(s string) uint32 {
+	if id, ok := n.id(s); ok {
+		n.names[id].refs++
+		return id
+	}
+
+	// keep the table at most three quarters full
+	if (n.used+1)*4 > len(n.slots)*3 {
+		n.resize()
+	}
+
+	nm := name{uint32(len(n.data)), 1}
+	var prefix [binary.MaxVarintLen64]byte
+	n.data = append(n.data, prefix[:binary.PutUvarint(prefix[:], uint64(len(s)))]...)
+	n.data = append(n.data, s...)
+
+	var id uint32
+	if len(n.free) > 0 {
+		id = n.free[len(n.free)-1]
+		n.free = n.free[:len(n.free)-1]
+		n.names[id] = nm
+	} else {
+		id = uint32(len(n.names))
+		n.names = append(n.names, nm)
+	}
+
+	mask := uint64(len(n.slots) - 1)
+	i := hash(s) & mask
+	for n.slots[i] != 0 && n.slots[i] != removedSlot {
+		i = (i + 1) & mask
+	}
+	if n.slots[i] == 0 {
+		n.used++
+	}
+	n.slots[i] = id + 1
+	return id
+}
+
+// release removes a reference to the name, removing it
+// when it isn't used anymore
+func (n *nameTable) release(id uint32) {
+	n.names[id].refs--
+	if n.names[id].refs > 0 {
+		return
+	}
+
+	b, size := n.get(id)
+	i, _ := n.slot(string(b))
+	n.slots[i] = removedSlot
+	n.garbage += size
+	n.names[id] = name{}
+	n.free = append(n.free, id)
+
+	if n.garbage >= minGarbage && n.garbage > len(n.data)/2 {
+		n.compact()
+	}
+}
+
+// resize rebuilds the hash table without the removed slots,
+// growing it if more than half of it is used by names
+func (n *nameTable) resize() {
+	live := len(n.names) - len(n.free)
+	size := len(n.slots)
+	for (live+1)*2 > size {
+		size *= 2
+	}
+
+	n.slots = make([]uint32, size)
+	n.used = live
+	mask := uint64(size - 1)
+	for id := range n.names {
+		if n.names[id].refs == 0 {
+			continue
+		}
+		i := hash(string(n.bytes(uint32(id)))) & mask
+		for n.slots[i] != 0 {
+			i = (i + 1) & mask
+		}
+		n.slots[i] = uint32(id) + 1
+	}
+}
+
+// compact removes the bytes of removed names from the storage
+func (n *nameTable) compact() {
+	data := make([]byte, 0, len(n.data)-n.garbage)
+	for id := range n.names {
+		if n.names[id].refs == 0 {
+			continue
+		}
+		offset := uint32(len(data))
+		_, size := n.get(uint32(id))
+		data = append(data, n.data[n.names[id].offset:n.names[id].offset+uint32(size)]...)
+		n.names[id].offset = offset
+	}
+	n.data = data
+	n.garbage = 0
+}
@@ -5,11 +5,55 @@ import (
 	"strings"
 )
 
-// Node is a node of a directory tree
+// none marks a missing entry or name
+const none = ^uint32(0)
+
+// largeDirectory is the number of children from which on the children
+// of a directory are looked up in a map instead of scanning them
+const largeDirectory = 32
+
+// the entries are allocated in chunks, so adding entries
+// doesn't copy the existing ones
+const (
+	chunkBits = 12
+	chunkSize = 1 << chunkBits
+)
+
+// Node is a handle to an entry of a directory tree,
+// it stays valid until the entry is deleted
 type Node struct {
-	children []*Node
-	name     string
-	parent   *Node
+	tree       *arena
+	index      uint32
+	generation uint32
+}
+
+// entry is an entry of the tree stored in the arena, the children of
+// an entry form a circular list, the first child's prev is the last one
+type entry struct {
+	name       uint32
+	parent     uint32
+	firstChild uint32
+	prev, next uint32
+	// value is stored for the user of the tree
+	value uint32
+	// generation is increased when the entry is deleted,
+	// so handles to it can be told apart from the handles
+	// to the entry that reuses its slot
+	generation uint32
+}
+
+// arena holds all entries of a tree, entries refer to each other
+// by their index instead of pointers
+type arena struct {
+	chunks []*[chunkSize]entry
+	count  uint32
+	// free holds the indexes of deleted entries that can be reused
+	free  []uint32
+	names nameTable
+	// lookup holds the children of large directories by their name
+	lookup map[uint32]map[uint32]uint32
+	// moves counts the calls of Move that moved an entry
+	moves uint64
 }
 
 // ErrInvalidPath is returned when the path given to one of
@@ -22,44 +66,229 @@ func (err ErrInvalidPath) Error() string {
 	return fmt.Sprintf("accesing invalid path: %s", err.path)
 }
 
-func (t Node) findFile(name string) (*Node, bool) {
-	for _, c := range t.children {
-		if c.name == name {
-			return c, true
+func (a *arena) at(index uint32) *entry {
+	return &a.chunks[index>>chunkBits][index&(chunkSize-1)]
+}
+
+// allocate returns the index of an unused entry
+func (a *arena) allocate() uint32 {
+	if len(a.free) > 0 {
+		index := a.free[len(a.free)-1]
+		a.free = a.free[:len(a.free)-1]
+		return index
+	}
+	if a.count%chunkSize == 0 {
+		a.chunks = append(a.chunks, new([chunkSize]entry))
+	}
+	a.count++
+	return a.count - 1
+}
+
+// children calls f for every child of parent until it returns false
+func (a *arena) children(parent uint32, f func(child uint32) bool) {
+	first := a.at(parent).firstChild
+	for child := first; child != none; {
+		next := a.at(child).next
+		if !f(child) || next == first {
+			return
+		}
+		child = next
+	}
+}
+
+// findFile returns the child of parent with the given name and the
+// number of children of parent, which is only counted up to
+// largeDirectory for directories without a lookup map
+func (a *arena) findFile(parent uint32, name string) (uint32, int) {
+	// no entry has the name if it isn't stored
+	id, stored := a.names.id(name)
+	if children, ok := a.lookup[parent]; ok {
+		if child, ok := children[id]; stored && ok {
+			return child, len(children)
+		}
+		return none, len(children)
+	}
+
+	found, scanned := none, 0
+	a.children(parent, func(child uint32) bool {
+		if stored && a.at(child).name == id {
+			found = child
+			return false
+		}
+		scanned++
+		return scanned < largeDirectory
+	})
+	return found, scanned
+}
+
+// childCount returns the number of children of parent, which is only
+// counted up to largeDirectory for directories without a lookup map
+func (a *arena) childCount(parent uint32) int {
+	if children, ok := a.lookup[parent]; ok {
+		return len(children)
+	}
+	count := 0
+	a.children(parent, func(child uint32) bool {
+		count++
+		return count < largeDirectory
+	})
+	return count
+}
+
+// addFile adds a child with the given name to parent,
+// siblings is the number of children parent already has
+func (a *arena) addFile(parent uint32, name string, siblings int) uint32 {
+	index := a.allocate()
+	e := a.at(index)
+	*e = entry{
+		name:       a.names.intern(name),
+		firstChild: none,
+		generation: e.generation,
+	}
+	a.link(parent, index, siblings)
+	return index
+}
+
+// link appends the entry at index to the children of parent,
+// siblings is the number of children parent already has
+func (a *arena) link(parent, index uint32, siblings int) {
+	e := a.at(index)
+	e.parent = parent
+	p := a.at(parent)
+	if p.firstChild == none {
+		p.firstChild = index
+		e.prev, e.next = index, index
+	} else {
+		first := a.at(p.firstChild)
+		last := a.at(first.prev)
+		e.prev, e.next = first.prev, p.firstChild
+		last.next = index
+		first.prev = index
+	}
+
+	if children, ok := a.lookup[parent]; ok {
+		children[e.name] = index
+	} else if siblings+1 >= largeDirectory {
+		a.index(parent)
+	}
+}
+
+// unlink removes the entry at index from the children of its parent
+func (a *arena) unlink(index uint32) {
+	e := a.at(index)
+	parent := a.at(e.parent)
+	if e.next == index {
+		parent.firstChild = none
+	} else {
+		a.at(e.prev).next = e.next
+		a.at(e.next).prev = e.prev
+		if parent.firstChild == index {
+			parent.firstChild = e.next
+		}
+	}
+	if children, ok := a.lookup[e.parent]; ok {
+		delete(children, e.name)
+		if len(children) < largeDirectory/2 {
+			delete(a.lookup, e.parent)
+		}
+	}
+	e.parent, e.prev, e.next = none, none, none
+}
+
+// index creates the lookup map of the children of parent
+func (a *arena) index(parent uint32) {
+	children := make(map[uint32]uint32)
+	a.children(parent, func(child uint32) bool {
+		children[a.at(child).name] = child
+		return true
+	})
+	a.lookup[parent] = children
+}
+
+// deleteFile removes the entry at index and all entries below it,
+// their slots are reused and the handles to them become invalid
+func (a *arena) deleteFile(index uint32) {
+	a.unlink(index)
+
+	stack := []uint32{index}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		a.children(current, func(child uint32) bool {
+			stack = append(stack, child)
+			return true
+		})
+
+		delete(a.lookup, current)
+		e := a.at(current)
+		a.names.release(e.name)
+		*e = entry{
+			name:       none,
+			parent:     none,
+			firstChild: none,
+			generation: e.generation + 1,
 		}
+		a.free = append(a.free, current)
 	}
-	return nil, false
 }
 
-func (t *Node) deleteFile(name string) bool {
-	for i := range t.children {
-		if t.children[i].name == name {
-			t.children[i] = t.children[len(t.children)-1]
-			t.children = t.children[:len(t.children)-1]
+// isBelow returns whether the entry at index is ancestor
+// or one of the entries below it
+func (a *arena) isBelow(index, ancestor uint32) bool {
+	for ; index != none; index = a.at(index).parent {
+		if index == ancestor {
 			return true
 		}
 	}
 	return false
 }
 
-// GetChildren returns the directoryies/files of a directory
-// determiend by path
-func (t *Node) GetChildren(path string) ([]string, error) {
-	parts := pathToParts(path)
-	current := t
+// valid returns whether the entry of the handle wasn't deleted
+func (t *Node) valid() bool {
+	return t.tree.at(t.index).generation == t.generation
+}
 
+// resolve returns the index of the entry at path below t
+func (t *Node) resolve(parts []string) (uint32, bool) {
+	current := t.index
 	for _, part := range parts {
-		if child, ok := current.findFile(part); ok {
-			current = child
-		} else {
-			return nil, ErrInvalidPath{path}
+		child, _ := t.tree.findFile(current, part)
+		if child == none {
+			return none, false
 		}
+		current = child
+	}
+	return current, true
+}
+
+// Find returns the directory/file at path
+func (t *Node) Find(path string) (*Node, error) {
+	if !t.valid() {
+		return nil, ErrInvalidPath{path}
 	}
+	current, ok := t.resolve(pathToParts(path))
+	if !ok {
+		return nil, ErrInvalidPath{path}
+	}
+	return &Node{t.tree, current, t.tree.at(current).generation}, nil
+}
 
-	keys := make([]string, 0, len(current.children))
-	for _, child := range current.children {
-		keys = append(keys, child.name)
+// GetChildren returns the directoryies/files of a directory
+// determiend by path
+func (t *Node) GetChildren(path string) ([]string, error) {
+	if !t.valid() {
+		return nil, ErrInvalidPath{path}
 	}
+	current, ok := t.resolve(pathToParts(path))
+	if !ok {
+		return nil, ErrInvalidPath{path}
+	}
+
+	keys := make([]string, 0)
+	t.tree.children(current, func(child uint32) bool {
+		keys = append(keys, t.tree.names.text(t.tree.at(child).name))
+		return true
+	})
 
 	return keys, nil
 }
@@ -67,64 +296,148 @@ func (t *Node) GetChildren(path string) ([]string, error) {
 // Add adds a directory to the directory tree
 func (t *Node) Add(path string) *Node {
 	parts := pathToParts(path)
-	current := t
+	current := t.index
 
 	for _, part := range parts {
-		if child, ok := current.findFile(part); ok {
-			current = child
-		} else {
-			newPart := make([]byte, len(part))
-			copy(newPart, []byte(part))
-			child = &Node{make([]*Node, 0), string(newPart), current}
-			current.children = append(current.children, child)
-			current = child
+		child, siblings := t.tree.findFile(current, part)
+		if child == none {
+			child = t.tree.addFile(current, part, siblings)
 		}
+		current = child
 	}
 
-	return current
+	return &Node{t.tree, current, t.tree.at(current).generation}
 }
 
 // DeleteAt deletes a directory and its subdirectories/files from the tree
 func (t *Node) DeleteAt(path string) error {
 	parts := pathToParts(path)
-	current := *t
-	for _, part := range parts[:len(parts)-1] {
-		if child, ok := current.findFile(part); ok {
-			current = *child
-		} else {
-			return ErrInvalidPath{path}
-		}
+	if len(parts) == 0 || !t.valid() {
+		return ErrInvalidPath{path}
 	}
-
-	ok := current.deleteFile(parts[len(parts)-1])
+	current, ok := t.resolve(parts)
 	if !ok {
 		return ErrInvalidPath{path}
 	}
+
+	t.tree.deleteFile(current)
 	return nil
 }
 
-func (t *Node) GetPath() string {
-	parts := make([]string, 0, 10) // faster?
-	current := t
+// Move moves the directory/file at from and everything below it to
+// to, replacing the entry at to, the handles to the moved entries stay
+// valid, entries can't be moved below themselves or replace one of
+// their parent directories
+// the paths of the moved entries change, so users that keep paths
+// by handle have to drop them when Moves returns a new count
+func (t *Node) Move(from, to string) error {
+	fromParts, toParts := pathToParts(from), pathToParts(to)
+	if len(fromParts) == 0 || !t.valid() {
+		return ErrInvalidPath{from}
+	}
+	if len(toParts) == 0 {
+		return ErrInvalidPath{to}
+	}
+	source, ok := t.resolve(fromParts)
+	if !ok {
+		return ErrInvalidPath{from}
+	}
+	parent, ok := t.resolve(toParts[:len(toParts)-1])
+	if !ok || t.tree.isBelow(parent, source) {
+		return ErrInvalidPath{to}
+	}
+
+	name := toParts[len(toParts)-1]
+	existing, _ := t.tree.findFile(parent, name)
+	if existing == source {
+		return nil
+	}
+	if existing != none {
+		if t.tree.isBelow(source, existing) {
+			return ErrInvalidPath{to}
+		}
+		t.tree.deleteFile(existing)
+	}
+
+	t.tree.unlink(source)
+	e := t.tree.at(source)
+	oldName := e.name
+	e.name = t.tree.names.intern(name)
+	t.tree.names.release(oldName)
+	// findFile stops counting at the entry it finds,
+	// so the siblings are counted once the source is unlinked
+	t.tree.link(parent, source, t.tree.childCount(parent))
+	t.tree.moves++
+	return nil
+}
+
+// Moves returns how often entries of the tree were moved
+func (t *Node) Moves() uint64 {
+	return t.tree.moves
+}
 
-	for ; current.parent != nil; current = current.parent {
+// GetPath returns the path of the entry,
+// which is empty if the entry was deleted
+func (t *Node) GetPath() string {
+	if !t.valid() {
+		return ""
+	}
+	parts := make([]uint32, 0, 10) // faster?
+	for current := t.tree.at(t.index); current.parent != none; current = t.tree.at(current.parent) {
 		parts = append(parts, current.name)
 	}
 
 	var builder strings.Builder
 	for i := len(parts) - 1; i >= 0; i-- {
 		builder.WriteString("/")
-		builder.WriteString(parts[i])
+		builder.Write(t.tree.names.bytes(parts[i]))
 	}
 
 	return builder.String()
 }
 
+// Value returns the value stored with the entry,
+// which is zero until it is set or if the entry was deleted
+func (t *Node) Value() uint32 {
+	if !t.valid() {
+		return 0
+	}
+	return t.tree.at(t.index).value
+}
+
+// SetValue stores v with the entry, e.g. the position
+// of the entry in a list that refers to it
+func (t *Node) SetValue(v uint32) {
+	if t.valid() {
+		t.tree.at(t.index).value = v
+	}
+}
+
+// PathLen returns the length of the path that GetPath returns
+// without building it
+func (t *Node) PathLen() int {
+	if !t.valid() {
+		return 0
+	}
+	length := 0
+	for current := t.tree.at(t.index); current.parent != none; current = t.tree.at(current.parent) {
+		length += 1 + len(t.tree.names.bytes(current.name))
+	}
+	return length
+}
+
 // New returns a new Node
 func New() *Node {
-	return &Node{make([]*Node, 0), "", nil}
+	a := &arena{
+		names:  newNameTable(),
+		lookup: make(map[uint32]map[uint32]uint32),
+	}
+	root := a.allocate()
+	*a.at(root) = entry{name: none, parent: none, firstChild: none}
+	return &Node{a, root, 0}
 }
 
 func pathToParts(path string) []string {
-	return strings.Split(path, "/")[1:]
+	// the root directory "/" has no parts
+	return strings.Split(strings.TrimSuffix(path, "/"), "/")[1:]
 }
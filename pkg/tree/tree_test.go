@@ -1,8 +1,14 @@
 package tree
 
 import (
+	"fmt"
+	"math/rand"
+	"path"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"testing/quick"
 )
 
 var files = []string{
@@ -104,6 +110,9 @@ func TestNode_AddGetPath(t *testing.T) {
 			if gotPath != tt.args.path {
 				t.Errorf("Node.GetPath() error, wanted %s, got %s", tt.args.path, gotPath)
 			}
+			if gotLen := newNode.PathLen(); gotLen != len(tt.args.path) {
+				t.Errorf("Node.PathLen() = %d, want %d", gotLen, len(tt.args.path))
+			}
 		})
 	}
 }
@@ -145,20 +154,369 @@ func TestNode_DeleteAt(t *testing.T) {
 }
 
 func TestNew(t *testing.T) {
+	got := New()
+	if children, err := got.GetChildren("/"); err != nil || len(children) != 0 {
+		t.Errorf("New().GetChildren() = %v, %v, want no children", children, err)
+	}
+	if path := got.GetPath(); path != "" {
+		t.Errorf("New().GetPath() = %q, want the empty path", path)
+	}
+
+	if path := got.Add("/home/user").GetPath(); path != "/home/user" {
+		t.Errorf("Node.GetPath() = %s, want /home/user", path)
+	}
+	if children, err := got.GetChildren("/home"); err != nil || !reflect.DeepEqual(children, []string{"user"}) {
+		t.Errorf("Node.GetChildren() = %v, %v, want [user]", children, err)
+	}
+}
+
+func TestNode_MoveLargeDirectory(t *testing.T) {
+	tree := New()
+	var want []string
+	for i := 0; i < largeDirectory; i++ {
+		name := fmt.Sprintf("file%d", i)
+		want = append(want, name)
+		tree.Add("/dir/" + name)
+	}
+
+	// replacing the first child and renaming inside of the directory
+	// keeps every child reachable
+	tree.Add("/other/file0")
+	if err := tree.Move("/other/file0", "/dir/file0"); err != nil {
+		t.Fatalf("Node.Move() error = %v", err)
+	}
+	if err := tree.Move("/dir/file1", "/dir/renamed"); err != nil {
+		t.Fatalf("Node.Move() error = %v", err)
+	}
+	want[1] = "renamed"
+	for i := 0; i < 2*largeDirectory; i++ {
+		name := fmt.Sprintf("moved%d", i)
+		want = append(want, name)
+		tree.Add("/other/" + name)
+		if err := tree.Move("/other/"+name, "/dir/"+name); err != nil {
+			t.Fatalf("Node.Move() error = %v", err)
+		}
+	}
+	for _, name := range want {
+		if _, err := tree.Find("/dir/" + name); err != nil {
+			t.Errorf("Node.Find() error = %v", err)
+		}
+	}
+	if got, err := tree.GetChildren("/dir"); err != nil || len(got) != len(want) {
+		t.Errorf("Node.GetChildren() = %v, %v, want %d children", got, err, len(want))
+	}
+}
+
+func TestNode_LargeDirectory(t *testing.T) {
+	tree := New()
+	var want []string
+	for i := 0; i < 4*largeDirectory; i++ {
+		name := fmt.Sprintf("file%d", i)
+		want = append(want, name)
+		tree.Add("/dir/" + name)
+	}
+	// adding existing entries doesn't duplicate them
+	for _, name := range want {
+		if path := tree.Add("/dir/" + name).GetPath(); path != "/dir/"+name {
+			t.Errorf("Node.GetPath() = %s, want %s", path, "/dir/"+name)
+		}
+	}
+	if got, err := tree.GetChildren("/dir"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Node.GetChildren() = %v, %v, want %v", got, err, want)
+	}
+
+	// directories shrinking below the threshold are scanned again
+	for _, name := range want[1:] {
+		if err := tree.DeleteAt("/dir/" + name); err != nil {
+			t.Fatalf("Node.DeleteAt() error = %v", err)
+		}
+	}
+	if got, err := tree.GetChildren("/dir"); err != nil || !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Node.GetChildren() = %v, %v, want %v", got, err, want[:1])
+	}
+}
+
+func TestNode_DeletedHandle(t *testing.T) {
+	tree := buildTree()
+	deleted := tree.Add("/home/user/Desktop/file3")
+	if err := tree.DeleteAt("/home/user/Desktop"); err != nil {
+		t.Fatalf("Node.DeleteAt() error = %v", err)
+	}
+	// the slot of the deleted entry is reused by the new one
+	added := tree.Add("/home/user/Desktop")
+	if path := deleted.GetPath(); path != "" {
+		t.Errorf("deleted Node.GetPath() = %s, want the empty path", path)
+	}
+	if path := added.GetPath(); path != "/home/user/Desktop" {
+		t.Errorf("Node.GetPath() = %s, want /home/user/Desktop", path)
+	}
+	if _, err := deleted.GetChildren("/"); err == nil {
+		t.Errorf("deleted Node.GetChildren() error = nil")
+	}
+}
+
+func TestNode_Churn(t *testing.T) {
+	tree := New()
+	long := strings.Repeat("x", 100)
+	var handles []*Node
+	for i := 0; i < 2000; i++ {
+		dir := "/dir0"
+		if i%4 == 0 {
+			dir = "/dir1"
+		}
+		handles = append(handles, tree.Add(fmt.Sprintf("%s/%s%d", dir, long, i)))
+	}
+	// removing the names compacts the name storage
+	if err := tree.DeleteAt("/dir0"); err != nil {
+		t.Fatalf("Node.DeleteAt() error = %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		tree.Add(fmt.Sprintf("/dir0/new%d", i))
+	}
+
+	for i, handle := range handles {
+		want := fmt.Sprintf("/dir1/%s%d", long, i)
+		if i%4 != 0 {
+			want = ""
+		}
+		if got := handle.GetPath(); got != want {
+			t.Fatalf("Node.GetPath() = %s, want %s", got, want)
+		}
+	}
+	if got, err := tree.GetChildren("/dir0"); err != nil || len(got) != 1000 || got[999] != "new999" {
+		t.Errorf("Node.GetChildren() = %d children, %v", len(got), err)
+	}
+}
+
+func TestNode_Move(t *testing.T) {
 	tests := []struct {
-		name string
-		want *Node
+		name     string
+		from, to string
+		wantErr  bool
+		// want are the children of the parent directory of to
+		want []string
 	}{
-		{
-			"default_test",
-			&Node{[]*Node{}, "", nil},
-		},
+		{"rename", "/home/user/Desktop", "/home/user/Pictures", false,
+			[]string{"Documents", "Downloads", "empty", "Pictures"}},
+		{"other_directory", "/home/user/Desktop/file3", "/home/user/empty/file3", false,
+			[]string{"file3"}},
+		{"replace", "/home/user/Downloads", "/home/user/Documents", false,
+			[]string{"empty", "Desktop", "Documents"}},
+		{"same_path", "/home/user/empty", "/home/user/empty", false,
+			[]string{"Documents", "Downloads", "empty", "Desktop"}},
+		{"below_itself", "/home/user", "/home/user/Desktop/user", true, nil},
+		{"replace_parent", "/home/user/Desktop", "/home/user", true, nil},
+		{"source_not_found", "/home/user/doesnotexist", "/home/user/new", true, nil},
+		{"parent_not_found", "/home/user/empty", "/home/user/doesnotexist/empty", true, nil},
+		{"root", "/", "/home/root", true, nil},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := New(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("New() = %v, want %v", got, tt.want)
+			tree := buildTree()
+			var moved *Node
+			if !tt.wantErr {
+				moved = tree.Add(tt.from)
+			}
+			if err := tree.Move(tt.from, tt.to); (err != nil) != tt.wantErr {
+				t.Fatalf("Node.Move() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got := moved.GetPath(); got != tt.to {
+				t.Errorf("moved Node.GetPath() = %s, want %s", got, tt.to)
+			}
+			got, err := tree.GetChildren(path.Dir(tt.to))
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Node.GetChildren() = %v, %v, want %v", got, err, tt.want)
 			}
 		})
 	}
 }
+
+func TestNode_FindValue(t *testing.T) {
+	tree := buildTree()
+	tree.Add("/home/user/Desktop/file3").SetValue(42)
+
+	found, err := tree.Find("/home/user/Desktop/file3")
+	if err != nil {
+		t.Fatalf("Node.Find() error = %v", err)
+	}
+	if got := found.Value(); got != 42 {
+		t.Errorf("Node.Value() = %d, want 42", got)
+	}
+	if _, err := tree.Find("/home/user/doesnotexist"); err == nil {
+		t.Errorf("Node.Find() error = nil for a missing path")
+	}
+
+	// the value stays with the entry when it's moved, but not
+	// with the entry that reuses its slot after deleting it
+	if err := tree.Move("/home/user/Desktop/file3", "/home/file3"); err != nil {
+		t.Fatalf("Node.Move() error = %v", err)
+	}
+	if got := found.Value(); got != 42 {
+		t.Errorf("moved Node.Value() = %d, want 42", got)
+	}
+	tree.DeleteAt("/home/file3")
+	if got := tree.Add("/home/file5").Value(); got != 0 {
+		t.Errorf("new Node.Value() = %d, want 0", got)
+	}
+}
+
+// model is a map based directory tree to test the tree against,
+// it holds every path in the tree and the expected paths of handles
+type model struct {
+	paths   map[string]bool
+	handles map[*Node]string
+}
+
+// below returns whether path is ancestor or one of the paths below it
+func below(path, ancestor string) bool {
+	return path == ancestor || strings.HasPrefix(path, ancestor+"/")
+}
+
+func (m *model) add(p string, handle *Node) {
+	for ; p != "/"; p = path.Dir(p) {
+		m.paths[p] = true
+	}
+	m.handles[handle] = handle.GetPath()
+}
+
+// remove removes p and everything below it, it returns false if p
+// doesn't exist
+func (m *model) remove(p string) bool {
+	if !m.paths[p] {
+		return false
+	}
+	for other := range m.paths {
+		if below(other, p) {
+			delete(m.paths, other)
+		}
+	}
+	for handle, handlePath := range m.handles {
+		if below(handlePath, p) {
+			m.handles[handle] = ""
+		}
+	}
+	return true
+}
+
+// move moves from and everything below it to to,
+// it returns false if the move isn't possible
+func (m *model) move(from, to string) bool {
+	parent := path.Dir(to)
+	if !m.paths[from] || (parent != "/" && !m.paths[parent]) ||
+		below(to, from) && to != from || below(from, to) && to != from {
+		return false
+	}
+	if from == to {
+		return true
+	}
+
+	m.remove(to)
+	for other := range m.paths {
+		if below(other, from) {
+			delete(m.paths, other)
+			m.paths[to+strings.TrimPrefix(other, from)] = true
+		}
+	}
+	for handle, handlePath := range m.handles {
+		if handlePath != "" && below(handlePath, from) {
+			m.handles[handle] = to + strings.TrimPrefix(handlePath, from)
+		}
+	}
+	return true
+}
+
+// check compares the tree with the model
+func (m *model) check(tree *Node) error {
+	children := map[string][]string{"/": nil}
+	for p := range m.paths {
+		// the entries without children are listed as well
+		children[p] = append(children[p], []string{}...)
+		parent := path.Dir(p)
+		children[parent] = append(children[parent], path.Base(p))
+	}
+	for p, want := range children {
+		got, err := tree.GetChildren(p)
+		if err != nil {
+			return fmt.Errorf("GetChildren(%s) error = %v", p, err)
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if len(got) != len(want) || len(got) > 0 && !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("GetChildren(%s) = %v, want %v", p, got, want)
+		}
+	}
+	for handle, want := range m.handles {
+		if got := handle.GetPath(); got != want {
+			return fmt.Errorf("GetPath() = %q, want %q", got, want)
+		}
+	}
+	return nil
+}
+
+// randomPath returns a path below one of many top level directories,
+// so the root directory grows beyond largeDirectory, and of few names
+// below them, so paths collide
+func randomPath(r *rand.Rand) string {
+	names := []string{"a", "b", "c", "d"}
+	p := fmt.Sprintf("/top%d", r.Intn(2*largeDirectory))
+	for depth := r.Intn(4); depth > 0; depth-- {
+		p += "/" + names[r.Intn(len(names))]
+	}
+	return p
+}
+
+func TestNode_Model(t *testing.T) {
+	property := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		tree := New()
+		m := model{map[string]bool{}, map[*Node]string{}}
+
+		for i := 0; i < 300; i++ {
+			var op string
+			switch from, to := randomPath(r), randomPath(r); r.Intn(3) {
+			case 0:
+				op = "Add(" + from + ")"
+				m.add(from, tree.Add(from))
+			case 1:
+				op = "DeleteAt(" + from + ")"
+				if err := tree.DeleteAt(from); (err == nil) != m.remove(from) {
+					t.Logf("seed %d: %s error = %v", seed, op, err)
+					return false
+				}
+			case 2:
+				op = "Move(" + from + ", " + to + ")"
+				if err := tree.Move(from, to); (err == nil) != m.move(from, to) {
+					t.Logf("seed %d: %s error = %v", seed, op, err)
+					return false
+				}
+			}
+			if err := m.check(tree); err != nil {
+				t.Logf("seed %d: after %s: %v", seed, op, err)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+		t.Error(err)
+	}
+}
+
+func BenchmarkNode_Add(b *testing.B) {
+	paths := make([]string, 0, 100000)
+	for i := 0; i < cap(paths); i++ {
+		paths = append(paths, fmt.Sprintf("/home/user/dir%d/sub%d/file%d", i%100, i%1000, i))
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tree := New()
+		for _, path := range paths {
+			tree.Add(path)
+		}
+	}
+}